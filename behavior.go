@@ -12,7 +12,7 @@
 package clyde
 
 import (
-	"log"
+	"context"
 	"fmt"
 	"strings"
 	"strconv"
@@ -22,11 +22,15 @@ import (
 	"os"
 	"path"
 	"time"
+	"sort"
+	"encoding/json"
+	"errors"
 	"github.com/zephyr-im/zephyr-go"
 	"github.com/sdukhovni/clyde-go/stringutil"
 	"github.com/sdukhovni/clyde-go/util"
 	"github.com/sdukhovni/clyde-go/mood"
 	"github.com/sdukhovni/clyde-go/cat"
+	"github.com/sdukhovni/clyde-go/markov"
 )
 
 // behavior represents a zephyrbot behavior. A behavior takes a Clyde
@@ -52,6 +56,7 @@ func standardBehavior(pattern string, keys []string, chain bool, resp func(*Clyd
 		if match == nil {
 			return false
 		}
+		c.matchedPattern = pattern
 
 		keyvals := make(map[string]string)
 		for _, key := range keys {
@@ -60,19 +65,37 @@ func standardBehavior(pattern string, keys []string, chain bool, resp func(*Clyd
 
 		response := resp(c, r, keyvals)
 		if chain {
-			response = c.chain.Generate(response, sentenceCounts[rand.Intn(len(sentenceCounts))], maxWords)
+			ctx, cancel := context.WithTimeout(context.Background(), generateTimeout)
+			defer cancel()
+			response = c.generate(ctx, response, sentenceCounts[c.rng.Intn(len(sentenceCounts))])
+			response = stringutil.RecapitalizeSentences(response)
+		}
+
+		// A behavior with nothing to say still claims the message
+		if response == "" {
+			return true
+		}
+
+		// Answer personals personally
+		if util.IsPersonal(r) {
+			c.sendPersonal(r, response)
+			return true
 		}
 
 		class := r.Message.Header.Class
 		instance := r.Message.Header.Instance
-		if class != homeClass || instance != homeInstance {
-			switch c.subs[class] {
+		if !c.isHome(class, instance) {
+			switch c.policyFor(class, instance) {
 			case 0, LISTEN:
 				return true
 			case REPLYHOME:
-				if !strings.HasPrefix(strings.ToLower(util.MessageBody(r)), "clyde") {
-					class = homeClass
-					instance = homeInstance
+				if !util.AddressedToClyde(r, sender) {
+					class = c.home().Class
+					instance = c.home().Instance
+				}
+			case REPLYHOMECLASS:
+				if !util.AddressedToClyde(r, sender) {
+					class = c.home().Class
 				}
 			}
 		}
@@ -83,10 +106,82 @@ func standardBehavior(pattern string, keys []string, chain bool, resp func(*Clyd
 	}
 }
 
+// perSenderCooldown generates a behavior that acts like b, except that
+// once a sender triggers it, it doesn't trigger for that sender (and
+// so doesn't run at all) until Clyde's configured cooldown has passed;
+// a later behavior may handle the message instead. Other senders are
+// unaffected. The name identifies the behavior for tracking cooldowns.
+func perSenderCooldown(name string, b behavior) behavior {
+	return func(c *Clyde, r zephyr.MessageReaderResult) bool {
+		key := fmt.Sprintf("%s %s", name, shortSender(r))
+		if last, ok := c.cooldowns[key]; ok && c.since(last) < c.config.SenderCooldown.Duration {
+			return false
+		}
+
+		if !b(c, r) {
+			return false
+		}
+		c.cooldowns[key] = c.clock.Now()
+		return true
+	}
+}
+
+// unlessMatches generates a behavior that acts like b, except that it
+// never triggers on messages matching the given (case-insensitive)
+// pattern.
+func unlessMatches(pattern string, b behavior) behavior {
+	rex := regexp.MustCompile(fmt.Sprint("(?i)", pattern))
+	return func(c *Clyde, r zephyr.MessageReaderResult) bool {
+		if rex.MatchString(util.MessageBody(r)) {
+			return false
+		}
+		return b(c, r)
+	}
+}
+
 // maxWords is the maximum number of words that a behavior should
 // generate using the markov chainer.
 const maxWords = 100
 
+// generateAttempts is how many times generate tries to produce a long
+// enough reply.
+const generateAttempts = 5
+
+// generateTimeout bounds how long a behavior spends generating a
+// reply, so a pathological chain can't stall the event loop.
+const generateTimeout = 2*time.Second
+
+// generate generates text following start, trying a few times to add
+// at least Clyde's configured minimum number of words to it, and
+// settling for the longest attempt otherwise. It gives up early when
+// ctx is done.
+func (c *Clyde) generate(ctx context.Context, start string, sentences int) string {
+	startWords := len(strings.Fields(start))
+	var best string
+	bestWords := -1
+	for i := 0; i < generateAttempts && (i == 0 || ctx.Err() == nil); i++ {
+		text := generateCtx(ctx, c.chain, start, sentences, maxWords)
+		words := len(strings.Fields(text))
+		if words > bestWords {
+			best, bestWords = text, words
+		}
+		if bestWords-startWords >= c.config.MinChainWords {
+			break
+		}
+	}
+	return best
+}
+
+// generateZsig generates a zsig from the zsigs Clyde has seen,
+// falling back to "Clyde" if he hasn't learned any.
+func (c *Clyde) generateZsig() string {
+	zsig := strings.TrimSpace(c.zsigChain.Generate("", 1, c.rng.Intn(zsigMaxRunes-zsigMinRunes+1)+zsigMinRunes))
+	if zsig == "" {
+		return "Clyde"
+	}
+	return zsig
+}
+
 // sentenceCounts is a set of sentence counts to request from the
 // chainer; a number is chosen randomly from this list each time a
 // number of sentences is needed.
@@ -105,7 +200,7 @@ func allLines(c *Clyde, filename string) ([]string, error) {
 
 	f, err := os.Open(filepath)
 	if err != nil {
-		log.Println(err)
+		c.log.Errorf("Error reading lines: %v", err)
 		return nil, err
 	}
 	defer f.Close()
@@ -129,7 +224,10 @@ func randomLine(c *Clyde, filename string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return lines[rand.Intn(len(lines))], nil
+	if len(lines) == 0 {
+		return "", fmt.Errorf("%s has no lines", filename)
+	}
+	return lines[c.rng.Intn(len(lines))], nil
 }
 
 // addLine adds a line to a file in Clyde's home directory.
@@ -138,7 +236,7 @@ func addLine(c *Clyde, filename, line string) error {
 
 	f, err := os.OpenFile(filepath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
 	if err != nil {
-		log.Println(err)
+		c.log.Errorf("Error adding line: %v", err)
 		return err
 	}
 	defer f.Close()
@@ -147,87 +245,255 @@ func addLine(c *Clyde, filename, line string) error {
 	return nil
 }
 
+// hasLine reports whether a file in Clyde's home directory already
+// contains the given line, ignoring case. A missing file contains no
+// lines.
+func hasLine(c *Clyde, filename, line string) bool {
+	if _, err := os.Stat(c.path(filename)); os.IsNotExist(err) {
+		return false
+	}
+	lines, _ := allLines(c, filename)
+	for _, l := range lines {
+		if strings.EqualFold(l, line) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadJSON decodes a JSON file in Clyde's home directory into v.
+func loadJSON(c *Clyde, filename string, v interface{}) error {
+	f, err := os.Open(c.path(filename))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	return dec.Decode(v)
+}
+
+// saveJSON encodes v as JSON into a file in Clyde's home directory.
+func saveJSON(c *Clyde, filename string, v interface{}) error {
+	f, err := os.Create(c.path(filename))
+	if err != nil {
+		c.log.Errorf("Error saving %s: %v", filename, err)
+		return err
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	err = enc.Encode(v)
+	if err != nil {
+		c.log.Errorf("Error saving %s: %v", filename, err)
+		return err
+	}
+
+	return nil
+}
+
+
+// registeredBehavior pairs a behavior with its name, for logging and
+// introspection, and an optional short description of how to trigger
+// it, for use by the help behavior.
+type registeredBehavior struct {
+	name string
+	behavior behavior
+	help string
+}
 
 // Behaviors is a list of behaviors to be attempted in the order
-// given.
-var behaviors = []behavior{
-	watchCat,
-	empathy,
-	addActLike,
-	actLike,
-	learnSecret,
-	tellSecret,
-	addSub,
-	checkSub,
-	getMood,
-	cheerup,
-	learnJob,
-	story,
-	fight,
-	fortune,
-	dice,
-	quip,
-	memSize,
-	chainStats,
-	ping,
-	chat,
+// given. It's filled in by init, since the help behavior needs to
+// refer back to it.
+var behaviors []registeredBehavior
+
+func init() {
+	behaviors = []registeredBehavior{
+		{"watchCat", watchCat, ""},
+		{"whereCat", whereCat, "where is <cat>?"},
+		{"giveBackCat", giveBackCat, ""},
+		{"stoleCat", stoleCat, ""},
+		{"echo", echo, "echo <text>"},
+		{"repeat", repeat, "repeat after me: <text>"},
+		{"empathy", empathy, ""},
+		{"karma", karma, ""},
+		{"addActLike", addActLike, "<person> says <phrase>"},
+		{"forgetActLike", forgetActLike, ""},
+		{"actLikeWho", actLikeWho, "who can you act like?"},
+		{"quoteSomeone", quoteSomeone, "quote someone"},
+		{"actLike", actLike, "act like <person>"},
+		{"learnSecret", learnSecret, ""},
+		{"tellSecret", tellSecret, "tell me a secret"},
+		{"addSub", addSub, "subscribe to <class> [-i <instance>]"},
+		{"checkSub", checkSub, ""},
+		{"mute", mute, "stop"},
+		{"unmute", unmute, ""},
+		{"pauseLearning", pauseLearning, ""},
+		{"setMood", setMood, ""},
+		{"getMood", getMood, "how are you?"},
+		{"talkSpeed", talkSpeed, "talk faster/slower"},
+		{"cheerup", cheerup, ""},
+		{"learnJob", learnJob, ""},
+		{"learnHowLike", learnHowLike, ""},
+		{"learnBored", learnBored, ""},
+		{"learnPlanet", learnPlanet, ""},
+		{"story", story, "tell me a story"},
+		{"fight", fight, ""},
+		{"coin", coin, "flip a coin"},
+		{"choose", choose, "<this> or <that>?"},
+		{"fortune", fortune, "fortune"},
+		{"detailedDice", detailedDice, "detailed NdM"},
+		{"dice", dice, "roll NdM"},
+		{"rollStats", rollStats, "roll stats"},
+		{"clock", clock, "what time is it (in <place>)?"},
+		{"pigLatin", pigLatin, "pig latin <phrase>"},
+		{"reverse", reverse, "reverse <text>"},
+		{"newZsig", newZsig, "(use a) new zsig"},
+		{"quip", quip, ""},
+		{"memSize", memSize, ""},
+		{"knowledge", knowledge, "how much do you know?"},
+		{"chainStats", chainStats, ""},
+		{"status", status, "how long have you been running?"},
+		{"help", help, ""},
+		{"why", why, "why did you say that?"},
+		{"ping", ping, ""},
+		{"karmaQuery", karmaQuery, "karma <thing>"},
+		{"karmaLeaders", karmaLeaders, "top/bottom karma"},
+		{"babble", babble, "say something"},
+		{"calc", calc, "what is <arithmetic>?"},
+		{"recallFact", recallFact, "what is <thing>?"},
+		{"define", define, "define <word>"},
+		{"learnDefinition", learnDefinition, "<word> means <definition>"},
+		{"learnFact", learnFact, "remember <thing> is <value>"},
+		{"forgetEverything", forgetEverything, ""},
+		{"setPrefixLen", setPrefixLen, ""},
+		{"chat", chat, ""},
+	}
 }
 
 
 func tryPlayCat(c *Clyde) {
 	c.cat.State = cat.TryPlay
-	c.send(c.cat.Class, c.cat.Instance, cat.CatCmd(cat.PlayCmds[rand.Intn(len(cat.PlayCmds))]))
+	c.cat.LastPlayCmd = c.cat.ChoosePlayCmd(c.rng)
+	c.send(c.cat.Class, c.cat.Instance, cat.CatCmd(c.cat.Name, c.cat.LastPlayCmd))
 }
 
 func tryScoopCat(c *Clyde) {
 	c.cat.State = cat.TryScoop
-	c.send(c.cat.Class, c.cat.Instance, cat.CatCmd("scoop"))
+	c.send(c.cat.Class, c.cat.Instance, cat.CatCmd(c.cat.Name, "scoop"))
+}
+
+var whereCat = standardBehavior("^clyde.? where('s| is) (?P<name>[^ \\?]+)\\??$",
+	[]string{"name"},
+	false,
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		if !strings.EqualFold(kvs["name"], c.cat.Name) {
+			return fmt.Sprintf("I haven't seen %s.", kvs["name"])
+		}
+		if c.cat.Class == "" {
+			return fmt.Sprintf("I haven't seen %s in a while...", c.cat.Name)
+		}
+		if c.cat.State == cat.Traveling {
+			return fmt.Sprintf("%s is traveling; I last saw her on -c %s -i %s.", c.cat.Name, c.cat.Class, c.cat.Instance)
+		}
+		return fmt.Sprintf("%s is %s on -c %s -i %s.", c.cat.Name, c.cat.State.Description(), c.cat.Class, c.cat.Instance)
+	})
+
+var giveBackCat = catBehavior("^clyde.? (give|bring|take) (back (?P<name>the cat|[^ \\.!]+)|(?P<name>the cat|[^ \\.!]+) back)",
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		if r.AuthStatus != zephyr.AuthYes {
+			return "You look sketchy, I don't trust you..."
+		}
+		if !c.cat.Stolen {
+			return fmt.Sprintf("I don't have %s!", c.cat.Name)
+		}
+
+		tryScoopCat(c)
+		return fmt.Sprintf("Ok, I'll take %s back to -c %s.", c.cat.Name, c.cat.StolenClass)
+	})
+
+var stoleCat = catBehavior("^clyde.? did you (steal|take|scoop) (?P<name>[^\\?]+)\\??$",
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		if !c.cat.Stolen {
+			return "Who, me? Never."
+		}
+		return fmt.Sprintf("%s has just been visiting for %s! I'll bring her back to -c %s soon.",
+			c.cat.Name, humanizeDuration(c.since(c.cat.StolenTime)), c.cat.StolenClass)
+	})
+
+// catBehavior generates a standard behavior that only triggers when
+// the pattern's "name" group names Clyde's cat or "the cat", so that
+// messages about anyone else are left for other behaviors.
+func catBehavior(pattern string, resp func(*Clyde, zephyr.MessageReaderResult, map[string]string) string) behavior {
+	rex := regexp.MustCompile(fmt.Sprint("(?i)", pattern))
+	b := standardBehavior(pattern, nil, false, resp)
+	return func(c *Clyde, r zephyr.MessageReaderResult) bool {
+		body := strings.Join(strings.Fields(util.MessageBody(r)), " ")
+		match := rex.FindStringSubmatch(body)
+		if match == nil {
+			return false
+		}
+		// The name may be captured by any of several groups
+		name := ""
+		for i, group := range rex.SubexpNames() {
+			if group == "name" && match[i] != "" {
+				name = match[i]
+				break
+			}
+		}
+		if !strings.EqualFold(name, c.cat.Name) && !strings.EqualFold(name, "the cat") {
+			return false
+		}
+		return b(c, r)
+	}
 }
 
 // watchCat is a special behavior for interacting with the cat and
 // keeping track of her whereabouts.
 func watchCat(c *Clyde, r zephyr.MessageReaderResult) bool {
-	if shortSender(r) != cat.CatName {
-		log.Printf("sender was %s", shortSender(r))
+	if shortSender(r) != c.cat.Name {
 		return false
 	}
 
-	log.Println("Saw cat")
-
 	body := util.MessageBody(r)
 
 	c.cat.Class = r.Message.Header.Class
 	c.cat.Instance = r.Message.Header.Instance
 
 	action, user := cat.ParseAction(body)
+	c.log.Debugf("Saw cat action %v (user %q) in state %v", action, user, c.cat.State)
 
 	// Is the cat interacting with us?
 	withUs := user == "clyde"
 
 	switch action {
+	case cat.NoAction:
+		// Not something we understand; leave the cat's state alone
+		return false
 	case cat.React:
 		if c.cat.State == cat.TryPlay && (withUs || user == "") {
-			c.mood = c.mood.Better().Better().AtLeastOk()
+			c.cat.PlaySucceeded()
+			c.mood = c.mood.BetterBy(2).AtLeastOk()
 			c.cat.State = cat.Normal
 			return true
 		}
 		c.cat.State = cat.Normal
 	case cat.Scooped:
 		if withUs {
-			log.Println("we scooped the cat")
+			c.log.Infof("We scooped the cat")
 			c.cat.State = cat.WeScooped
 			if c.cat.Stolen {
-				c.send(c.cat.StolenClass, c.cat.StolenInstance, fmt.Sprintf("Thanks for visiting, %s!", cat.CatName))
+				c.send(c.cat.StolenClass, c.cat.StolenInstance, fmt.Sprintf("Thanks for visiting, %s!", c.cat.Name))
 				c.cat.Stolen = false
 			} else {
-				c.send(homeClass, homeInstance, fmt.Sprintf("Let's go over here, %s", cat.CatName))
+				c.sendHome(fmt.Sprintf("Let's go over here, %s", c.cat.Name))
 				c.cat.Stolen = true
-				c.cat.StolenTime = time.Now()
+				c.cat.StolenTime = c.clock.Now()
 				c.cat.StolenClass = c.cat.Class
 				c.cat.StolenInstance = c.cat.Instance
 			}
 		} else {
-			log.Println("someone else scooped the cat")
+			c.log.Infof("Someone else scooped the cat")
 			c.cat.State = cat.Normal
 		}
 	case cat.ScoopFailed:
@@ -245,7 +511,7 @@ func watchCat(c *Clyde, r zephyr.MessageReaderResult) bool {
 	case cat.Enter:
 		if withUs {
 			c.cat.State = cat.TryDeposit
-			c.send(c.cat.Class, c.cat.Instance, cat.CatCmd("deposit"))
+			c.send(c.cat.Class, c.cat.Instance, cat.CatCmd(c.cat.Name, "deposit"))
 		} else {
 			c.cat.State = cat.Normal
 		}
@@ -257,17 +523,17 @@ func watchCat(c *Clyde, r zephyr.MessageReaderResult) bool {
 		}
 	case cat.Bored:
 		c.cat.State = cat.Normal
-		if time.Since(c.lastInteraction) > time.Hour && rand.Intn(2) == 0 {
-			switch rand.Intn(8) {
-			case 0:
+		if c.since(c.lastInteraction) > c.config.CatBoredAfter.Duration && c.rng.Float64() < c.config.CatBoredChance {
+			if c.rng.Intn(2) == 0 {
 				tryScoopCat(c)
-			case 1:
+			} else {
 				tryPlayCat(c)
 			}
 		}
 	default:
 		c.cat.State = cat.Normal
 	}
+	c.log.Debugf("Cat state is now %v", c.cat.State)
 
 	if c.mood == mood.Lonely && c.cat.State == cat.Normal {
 		tryPlayCat(c)
@@ -279,6 +545,12 @@ func watchCat(c *Clyde, r zephyr.MessageReaderResult) bool {
 // Special behavior to update Clyde's mood based on incoming messages;
 // always returns false.
 func empathy(c *Clyde, r zephyr.MessageReaderResult) bool {
+	// Nobody likes being shouted at
+	body := util.MessageBody(r)
+	if util.AddressedToClyde(r, sender) && stringutil.IsShouting(body) {
+		c.mood = c.mood.Worse()
+	}
+
 	rex := regexp.MustCompile("(?i)(?P<emote>:[\\(\\)D3]|;\\(|:,\\(|happy|smile|laugh|sad|frown|cry)")
 	match := rex.FindStringSubmatchIndex(util.MessageBody(r))
 	if match == nil {
@@ -289,7 +561,7 @@ func empathy(c *Clyde, r zephyr.MessageReaderResult) bool {
 
 	switch emote {
 	case ":D", ":3", "laugh":
-		if rand.Intn(2) == 0 {
+		if c.rng.Intn(2) == 0 {
 			c.mood = c.mood.Better()
 		}
 		fallthrough
@@ -297,7 +569,7 @@ func empathy(c *Clyde, r zephyr.MessageReaderResult) bool {
 		c.mood = c.mood.Better()
 
 	case ";(", ":,(", "cry":
-		if rand.Intn(2) == 0 {
+		if c.rng.Intn(2) == 0 {
 			c.mood = c.mood.Worse()
 		}
 		fallthrough
@@ -308,13 +580,109 @@ func empathy(c *Clyde, r zephyr.MessageReaderResult) bool {
 	return false
 }
 
+const karmaFile = "karma.json"
+
+// loadKarma returns the karma scores Clyde has tracked, or an empty
+// map if none have been saved yet.
+func loadKarma(c *Clyde) map[string]int {
+	scores := make(map[string]int)
+	err := loadJSON(c, karmaFile, &scores)
+	if err != nil && !os.IsNotExist(err) {
+		c.log.Errorf("Error loading karma: %v", err)
+	}
+	return scores
+}
+
+// Special behavior to track karma for "thing++" and "thing--" tokens
+// in incoming messages; senders can't change their own karma. Always
+// returns false.
+func karma(c *Clyde, r zephyr.MessageReaderResult) bool {
+	var changes []string
+	for _, word := range strings.Fields(util.MessageBody(r)) {
+		word = strings.TrimRight(word, ",.!?;:")
+		if len(word) > 2 && (strings.HasSuffix(word, "++") || strings.HasSuffix(word, "--")) {
+			changes = append(changes, strings.ToLower(word))
+		}
+	}
+	if len(changes) == 0 {
+		return false
+	}
+
+	scores := loadKarma(c)
+	for _, change := range changes {
+		thing := change[:len(change)-2]
+		if thing == strings.ToLower(shortSender(r)) {
+			c.log.Infof("%s tried to change their own karma", thing)
+			continue
+		}
+		if strings.HasSuffix(change, "++") {
+			scores[thing]++
+		} else {
+			scores[thing]--
+		}
+	}
+	saveJSON(c, karmaFile, scores)
+
+	return false
+}
+
+var karmaQuery = standardBehavior("^clyde.? karma (?P<thing>[^ \\?]+)\\??$",
+	[]string{"thing"},
+	false,
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		thing := strings.ToLower(kvs["thing"])
+		return fmt.Sprintf("%s has %d karma.", thing, loadKarma(c)[thing])
+	})
+
+// karmaLeaderboardSize is the number of things listed by
+// karmaLeaders.
+const karmaLeaderboardSize = 5
+
+var karmaLeaders = standardBehavior("^clyde.? (?P<end>top|bottom) karma\\??$",
+	[]string{"end"},
+	false,
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		scores := loadKarma(c)
+		if len(scores) == 0 {
+			return "Nobody has any karma yet!"
+		}
+
+		var things []string
+		for thing := range scores {
+			things = append(things, thing)
+		}
+		bottom := strings.ToLower(kvs["end"]) == "bottom"
+		sort.Slice(things, func(i, j int) bool {
+			a, b := scores[things[i]], scores[things[j]]
+			if a == b {
+				return things[i] < things[j]
+			}
+			if bottom {
+				return a < b
+			}
+			return a > b
+		})
+		if len(things) > karmaLeaderboardSize {
+			things = things[:karmaLeaderboardSize]
+		}
+
+		var entries []string
+		for _, thing := range things {
+			entries = append(entries, fmt.Sprintf("%s: %d", thing, scores[thing]))
+		}
+		return strings.Join(entries, ", ")
+	})
+
 var addActLike = standardBehavior("clyde.? (?P<person>.+) says,? (\"(?P<phrase>[^\"]+)\".?|'(?P<phrase>[^']+)'.?|(?P<phrase>[^\"']+)|(?P<phrase>.+[\"'].+))$",
 	[]string{"person", "phrase"},
 	false,
 	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
 		alDir := c.path("al")
 		os.MkdirAll(alDir, 0755)
-		filename := path.Join("al", stringutil.Escape(strings.ToLower(kvs["person"])))
+		filename := actLikeFile(kvs["person"])
+		if hasLine(c, filename, kvs["phrase"]) {
+			return "I already knew that!"
+		}
 		addLine(c, filename, kvs["phrase"])
 		return "Ok!"
 	})
@@ -323,11 +691,9 @@ var actLike = standardBehavior("clyde.? ((please )?act like (?P<person>.*[^\\.\\
 	[]string{"person", "punc"},
 	false,
 	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
-		filename := path.Join("al", stringutil.Escape(strings.ToLower(kvs["person"])))
-		phrase, err := randomLine(c, filename)
+		phrase, err := randomLine(c, actLikeFile(kvs["person"]))
 		if err != nil {
-			filename = path.Join("al", stringutil.Escape(strings.ToLower(fmt.Sprint(kvs["person"], kvs["punc"]))))
-			phrase, err = randomLine(c, filename)
+			phrase, err = randomLine(c, actLikeFile(fmt.Sprint(kvs["person"], kvs["punc"])))
 			if err != nil {
 				return fmt.Sprintf("I don't know how to act like %s.", kvs["person"])
 			}
@@ -335,6 +701,98 @@ var actLike = standardBehavior("clyde.? ((please )?act like (?P<person>.*[^\\.\\
 		return phrase
 	})
 
+// actLikeFile returns the path of the file (relative to Clyde's home
+// directory) holding phrases for acting like the given person.
+func actLikeFile(person string) string {
+	return path.Join("al", stringutil.Escape(strings.ToLower(person)))
+}
+
+var forgetActLike = standardBehavior("clyde.? (please )?forget how to act like (?P<person>.*[^\\.\\?!])[\\.\\?!]*$",
+	[]string{"person"},
+	false,
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		if r.AuthStatus != zephyr.AuthYes {
+			return "You look sketchy, I don't trust you..."
+		}
+
+		// Make sure we only ever delete files directly in the
+		// act-like directory
+		filename := actLikeFile(kvs["person"])
+		if path.Dir(filename) != "al" {
+			return fmt.Sprintf("I don't know how to act like %s anyway.", kvs["person"])
+		}
+
+		err := os.Remove(c.path(filename))
+		if os.IsNotExist(err) {
+			return fmt.Sprintf("I don't know how to act like %s anyway.", kvs["person"])
+		} else if err != nil {
+			c.log.Errorf("Error forgetting act-like file: %v", err)
+			return "Hmm, I can't seem to forget..."
+		}
+		return fmt.Sprintf("Ok, I've forgotten how to act like %s.", kvs["person"])
+	})
+
+var actLikeWhoPattern = regexp.MustCompile("(?i)clyde.*who (can|do) you (act like|imitate)")
+
+// actLikeWho lists the people Clyde knows how to act like, replying on
+// Clyde's home class.
+func actLikeWho(c *Clyde, r zephyr.MessageReaderResult) bool {
+	if !actLikeWhoPattern.MatchString(util.MessageBody(r)) {
+		return false
+	}
+
+	entries, err := os.ReadDir(c.path("al"))
+	if err != nil && !os.IsNotExist(err) {
+		c.log.Errorf("Error listing act-like files: %v", err)
+	}
+
+	var people []string
+	for _, entry := range entries {
+		person, err := stringutil.Unescape(entry.Name())
+		if err != nil {
+			c.log.Warnf("Skipping act-like file %q: %v", entry.Name(), err)
+			continue
+		}
+		people = append(people, person)
+	}
+	sort.Strings(people)
+
+	if len(people) == 0 {
+		c.sendHome("I can't act like anyone yet.")
+	} else {
+		c.sendHome(fmt.Sprintf("I can act like %s.", strings.Join(people, ", ")))
+	}
+
+	return true
+}
+
+var quoteSomeone = standardBehavior("^clyde.? quote (someone|somebody|anyone)[\\.!]*$",
+	[]string{},
+	false,
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		entries, err := os.ReadDir(c.path("al"))
+		if err != nil && !os.IsNotExist(err) {
+			c.log.Errorf("Error listing act-like files: %v", err)
+		}
+
+		// Try people in a random order, since some may have no
+		// phrases left
+		for _, i := range c.rng.Perm(len(entries)) {
+			name := entries[i].Name()
+			person, err := stringutil.Unescape(name)
+			if err != nil {
+				c.log.Warnf("Skipping act-like file %q: %v", name, err)
+				continue
+			}
+			phrase, err := randomLine(c, path.Join("al", name))
+			if err != nil {
+				continue
+			}
+			return fmt.Sprintf("%s once said: %s", person, phrase)
+		}
+		return "I don't know what anyone's said yet."
+	})
+
 var learnSecret = standardBehavior("clyde.*don't tell anyone,? but (?P<secret>.+)",
 	[]string{"secret"},
 	false,
@@ -351,20 +809,24 @@ var tellSecret = standardBehavior("clyde.*tell me a secret",
 		return fmt.Sprintf("Don't tell anyone, but %s", secret)
 	})
 
-var addSub = standardBehavior("clyde.*sub(scribe)? to (me|my class|(-c )?(?P<class>[^ !\\?]+[^ !\\?\\.]))",
-	[]string{"class"},
+var addSub = standardBehavior("clyde.*sub(scribe)? to (me|my class|(-c )?(?P<class>[^ !\\?]+[^ !\\?\\.]))( -i (?P<instance>[^ !\\?]+[^ !\\?\\.]))?",
+	[]string{"class", "instance"},
 	false,
 	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
 		class := kvs["class"]
 		if class == "" {
 			class = shortSender(r)
 		}
+		instance := kvs["instance"]
+		if instance == "" {
+			instance = "*"
+		}
 
-		if r.Message.Header.Class != homeClass || r.Message.Header.Instance != homeInstance {
+		if !c.isHome(r.Message.Header.Class, r.Message.Header.Instance) {
 			return "I'm subbed to a lot of classes right now; maybe another time..."
 		}
 
-		if c.subs[class] != 0 {
+		if c.subs[class].Policy != 0 {
 			return fmt.Sprintf("I'm already subbed to -c %s!", class)
 		}
 
@@ -372,24 +834,182 @@ var addSub = standardBehavior("clyde.*sub(scribe)? to (me|my class|(-c )?(?P<cla
 			return "You look sketchy, I don't trust you..."
 		}
 
-		c.subscribe(class, REPLYHOME)
+		c.subscribe(class, instance, REPLYHOME)
+		if instance != "*" {
+			return fmt.Sprintf("-c %s -i %s sounds awesome! Thanks for the invitation :)", class, instance)
+		}
 		return fmt.Sprintf("-c %s sounds awesome! Thanks for the invitation :)", class)
 	})
 
-var checkSub = standardBehavior("are you (on|sub(scri)?bed to) (me|my class|(-c )?(?P<class>[^ !\\?]+[^ !\\?\\.]))",
-	[]string{"class"},
+var checkSub = standardBehavior("are you ((there )?on|sub(scri)?bed to) (me|my class|(-c )?(?P<class>[^ !\\?]+[^ !\\?\\.]))( -i (?P<instance>[^ !\\?]*[^ !\\?\\.]))?",
+	[]string{"class", "instance"},
 	false,
 	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
 		class := kvs["class"]
 		if class == "" {
 			class = shortSender(r)
 		}
+		instance := kvs["instance"]
 
-		if c.subs[class] == 0 {
+		sub := c.subs[class]
+		if sub.Policy == 0 {
+			if instance != "" {
+				return fmt.Sprintf("I'm not subbed to -c %s -i %s.", class, instance)
+			}
 			return fmt.Sprintf("I'm not subbed to -c %s.", class)
+		}
+
+		// A subscription to a single instance doesn't cover the
+		// whole class, or any other instance
+		if sub.Instance != "*" && (instance == "" || !sub.covers(instance)) {
+			return fmt.Sprintf("I'm only subbed to -c %s -i %s.", class, sub.Instance)
+		}
+		if instance != "" {
+			return fmt.Sprintf("Yup, I'm subbed to -c %s -i %s! It's my favorite instance :)", class, instance)
+		}
+		return fmt.Sprintf("Yup, I'm subbed to -c %s! It's my favorite class :)", class)
+	})
+
+var mute = standardBehavior("^clyde.? (stop|shut up|be quiet( on here)?)[\\.!]*$", []string{}, false,
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		class := r.Message.Header.Class
+		if c.isHome(class, r.Message.Header.Instance) {
+			return "But I live here!"
+		}
+		if r.AuthStatus != zephyr.AuthYes {
+			return "You look sketchy, I don't trust you..."
+		}
+
+		sub := c.subs[class]
+		if sub.Policy == 0 || sub.Policy == LISTEN {
+			return ""
+		}
+
+		// Say sorry now, since Clyde won't reply here once he's
+		// muted
+		c.send(class, r.Message.Header.Instance, "Sorry! I'll be quiet.")
+		sub.MutedPolicy = sub.Policy
+		sub.Policy = LISTEN
+		c.subs[class] = sub
+		err := c.saveSubs()
+		if err != nil {
+			c.log.Errorf("Error saving subscriptions: %v", err)
+		}
+		return ""
+	})
+
+var unmute = standardBehavior("^clyde.? you can (talk|speak) again", []string{}, false,
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		class := r.Message.Header.Class
+		sub := c.subs[class]
+		if sub.MutedPolicy == 0 {
+			return ""
+		}
+		if r.AuthStatus != zephyr.AuthYes {
+			return "" // Clyde is still muted, so this wouldn't be sent
+		}
+
+		sub.Policy = sub.MutedPolicy
+		sub.MutedPolicy = 0
+		c.subs[class] = sub
+		err := c.saveSubs()
+		if err != nil {
+			c.log.Errorf("Error saving subscriptions: %v", err)
+		}
+		return "Yay! I missed you all."
+	})
+
+// moodNames returns a regexp alternation matching the name of any
+// mood, without the leading "a" of "a turnip".
+func moodNames() string {
+	var names []string
+	for _, m := range mood.All() {
+		names = append(names, regexp.QuoteMeta(strings.TrimPrefix(m.String(), "a ")))
+	}
+	return strings.Join(names, "|")
+}
+
+// setMood only claims "clyde, be ..." for actual moods, since people
+// say that to Clyde for all sorts of reasons, but "clyde, set mood
+// ..." is unambiguous, so it explains what moods there are.
+var setMood = standardBehavior("^clyde.? (be (a )?(?P<mood>"+moodNames()+")|set mood( to)? (?P<mood>[^\\.!]+))[\\.!]*$",
+	[]string{"mood"},
+	false,
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		if r.AuthStatus != zephyr.AuthYes {
+			return "You look sketchy, I don't trust you..."
+		}
+
+		m, err := mood.FromString(kvs["mood"])
+		if err != nil {
+			return fmt.Sprintf("I don't know how to be %s. Try %s.", kvs["mood"], strings.Replace(moodNames(), "|", ", ", -1))
+		}
+
+		c.mood = m
+		return fmt.Sprintf("Ok, now I'm %s%s", c.mood.String(), c.mood.Punc())
+	})
+
+var pauseLearning = standardBehavior("^clyde.? (?P<action>stop|start) learning[\\.!]*$",
+	[]string{"action"},
+	false,
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		if r.AuthStatus != zephyr.AuthYes {
+			return "You look sketchy, I don't trust you..."
+		}
+
+		paused := strings.EqualFold(kvs["action"], "stop")
+		if paused == c.config.LearningPaused {
+			if paused {
+				return "I'm not learning anything right now."
+			}
+			return "I'm already learning!"
+		}
+
+		c.config.LearningPaused = paused
+		err := c.saveConfig("LearningPaused")
+		if err != nil {
+			c.log.Errorf("Error saving config: %v", err)
+		}
+		if paused {
+			return "Ok, I'll stop learning for now."
+		}
+		return "Ok, I'm learning again!"
+	})
+
+var talkSpeed = standardBehavior("^clyde.? (talk|type) (?P<speed>faster|slower)",
+	[]string{"speed"},
+	false,
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		if r.AuthStatus != zephyr.AuthYes {
+			return "You look sketchy, I don't trust you..."
+		}
+
+		factor := c.config.SendDelayFactor
+		faster := strings.ToLower(kvs["speed"]) == "faster"
+		if faster {
+			factor /= 2
+			if factor < minSendDelayFactor {
+				factor = minSendDelayFactor
+			}
 		} else {
-			return fmt.Sprintf("Yup, I'm subbed to -c %s! It's my favorite class :)", class)
+			factor *= 2
+			if factor < minSendDelayFactor {
+				factor = minSendDelayFactor
+			}
+			if factor > maxSendDelayFactor {
+				factor = maxSendDelayFactor
+			}
+		}
+		if factor == c.config.SendDelayFactor || (faster && factor > c.config.SendDelayFactor) {
+			return fmt.Sprintf("I can't type any %s than this!", strings.ToLower(kvs["speed"]))
 		}
+
+		c.config.SendDelayFactor = factor
+		err := c.saveConfig("SendDelayFactor")
+		if err != nil {
+			c.log.Errorf("Error saving config: %v", err)
+		}
+		return fmt.Sprintf("Ok, now I'm typing at %d characters per second.", 1000/factor)
 	})
 
 var getMood = standardBehavior("clyde.* how are you", []string{}, false,
@@ -411,32 +1031,118 @@ var learnJob = standardBehavior("clyde.? (?P<job>.+) is an? (job|profession|occu
 		return "That's what I wanna be when I grow up!"
 	})
 
-var story = standardBehavior("tell me a story",
+var learnHowLike = standardBehavior("^clyde.? you like (?P<thing>.+?) because (?P<reason>.+)$",
+	[]string{"thing", "reason"},
+	false,
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		if !hasLine(c, "howlike", kvs["reason"]) {
+			addLine(c, "howlike", kvs["reason"])
+		}
+		return fmt.Sprintf("Oh yeah, I do like %s!", kvs["thing"])
+	})
+
+var learnBored = standardBehavior("^clyde.? when you('re| are) bored,? say (?P<phrase>.+)$",
+	[]string{"phrase"},
+	false,
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		if hasLine(c, "bored", kvs["phrase"]) {
+			return "I already say that!"
+		}
+		addLine(c, "bored", kvs["phrase"])
+		return "Ok, I'll keep that in mind for next time I'm bored."
+	})
+
+var learnPlanet = standardBehavior("^clyde.? (?P<planet>[^ ]+( [^ ]+)?) is a planet[\\.!]*$",
+	[]string{"planet"},
+	false,
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		if hasLine(c, "planets", kvs["planet"]) {
+			return fmt.Sprintf("I know, %s is one of my favorite planets!", kvs["planet"])
+		}
+		addLine(c, "planets", kvs["planet"])
+		return fmt.Sprintf("Whoa, I'll have to visit %s sometime!", kvs["planet"])
+	})
+
+var story = perSenderCooldown("story", standardBehavior("tell me a story",
 	nil,
 	true,
 	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
 		job, _ := randomLine(c, "jobs")
 		return fmt.Sprintf("Once upon a time, there was %s %s named %s who", stringutil.Article(job), job, shortSender(r))
+	}))
+
+var fight = unlessMatches("\\b(distance|difference|relationship|time)\\b",
+	standardBehavior("if (?P<fight1>.+) and (?P<fight2>.+) (fought|duell?ed|(got in|had) a (fight|duel)).*win\\?|(fight|duel|battle|showdown) between (?P<fight1>.+) and (?P<fight2>.+[^,\\?])\\?",
+		[]string{"fight1", "fight2"},
+		true,
+		func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+			var winner string
+			switch c.rng.Intn(2) {
+			case 0:
+				winner = "fight1"
+			case 1:
+				winner = "fight2"
+			}
+			return fmt.Sprintf("I think %s would win, because", kvs[winner])
+		}))
+
+// maxCoins is the most coins Clyde will flip at once.
+const maxCoins = 20
+
+var coin = standardBehavior("(flip|toss) (a|(?P<count>[0-9]+)) coins?|heads or tails",
+	[]string{"count"},
+	false,
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		count := 1
+		if kvs["count"] != "" {
+			var err error
+			count, err = strconv.Atoi(kvs["count"])
+			if err != nil {
+				count = maxCoins + 1
+			}
+		}
+		if count > maxCoins {
+			return "I don't have that many coins!"
+		}
+
+		var flips []string
+		for i := 0; i < count; i++ {
+			if c.rng.Intn(2) == 0 {
+				flips = append(flips, "heads")
+			} else {
+				flips = append(flips, "tails")
+			}
+		}
+		if len(flips) == 0 {
+			return "Ok, I flipped no coins."
+		}
+		return stringutil.Capitalize(strings.Join(flips, ", "))
 	})
 
-var fight = standardBehavior("if (?P<fight1>.+) and (?P<fight2>.+) (fought|duell?ed|(got in|had) a (fight|duel)).*win\\?|(fight|duel) between (?P<fight1>.+) and (?P<fight2>.+[^,\\?])\\?",
-	[]string{"fight1", "fight2"},
-	true,
+// optionSeparator splits a list of options like "a, b, or c".
+var optionSeparator = regexp.MustCompile("(?i)\\s*,\\s*(or\\s+)?|\\s+or\\s+")
+
+var choose = standardBehavior("^clyde.? (?P<options>[^\\?]+ or [^\\?]+?)\\?*$",
+	[]string{"options"},
+	false,
 	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
-		var winner string
-		switch rand.Intn(2) {
-		case 0:
-			winner = "fight1"
-		case 1:
-			winner = "fight2"
+		var options []string
+		for _, option := range optionSeparator.Split(kvs["options"], -1) {
+			option = strings.TrimSpace(option)
+			if option != "" {
+				options = append(options, option)
+			}
+		}
+		if len(options) == 0 {
+			return "Hmm, I can't decide."
 		}
-		return fmt.Sprintf("I think %s would win, because", kvs[winner])
+		return stringutil.Capitalize(options[c.rng.Intn(len(options))])
 	})
 
 var fortune = standardBehavior("fortune", []string{}, false,
 	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
 		var intros []string
-		switch rand.Intn(3) {
+		switch c.rng.Intn(3) {
 		case 0:
 			intros = []string{
 				fmt.Sprintf("%s, yesterday you were", shortSender(r)),
@@ -457,83 +1163,196 @@ var fortune = standardBehavior("fortune", []string{}, false,
 				"For yourself, you should",
 			}
 		}
+		ctx, cancel := context.WithTimeout(context.Background(), generateTimeout)
+		defer cancel()
 		var response []string
 		for _, intro := range intros {
-			response = append(response, c.chain.Generate(intro, 1, maxWords))
+			response = append(response, generateCtx(ctx, c.chain, intro, 1, maxWords))
 		}
 		return strings.Join(response, " ")
 	})
 
-var dice = standardBehavior("( |^)(?P<count>[0-9]*)d(?P<faces>[0-9]+)",
+// rollDice rolls count dice with the given number of faces, returning
+// the result of each roll.
+func rollDice(rng *rand.Rand, count, faces int) []int {
+	if faces <= 0 {
+		return nil
+	}
+	rolls := make([]int, count)
+	for i := range rolls {
+		rolls[i] = rng.Intn(faces) + 1
+	}
+	return rolls
+}
+
+// maxDice is the most dice Clyde will roll at once.
+const maxDice = 100
+
+// errTooManyDice is returned by diceArgs when asked to roll more than
+// maxDice dice.
+var errTooManyDice = errors.New("too many dice")
+
+// diceArgs parses the count and faces captured by a dice regexp; a
+// missing count means a single die. It returns an error if either
+// number doesn't parse, or if there are more than maxDice dice.
+func diceArgs(kvs map[string]string) (int, int, error) {
+	count := 1
+	if kvs["count"] != "" {
+		var err error
+		count, err = strconv.Atoi(kvs["count"])
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if count > maxDice {
+		return 0, 0, errTooManyDice
+	}
+	faces, err := strconv.Atoi(kvs["faces"])
+	if err != nil {
+		return 0, 0, err
+	}
+	return count, faces, nil
+}
+
+// diceError returns Clyde's reply to a dice roll diceArgs rejected.
+func diceError(err error) string {
+	if err == errTooManyDice {
+		return "I don't have that many dice!"
+	}
+	return "Those are some weird dice."
+}
+
+// sum returns the total of a list of rolls.
+func sum(rolls []int) int {
+	total := 0
+	for _, roll := range rolls {
+		total += roll
+	}
+	return total
+}
+
+// formatRolls formats a list of rolls along with their total, e.g.
+// "3, 5, 1, 6 = 15".
+func formatRolls(rolls []int) string {
+	var parts []string
+	for _, roll := range rolls {
+		parts = append(parts, strconv.Itoa(roll))
+	}
+	return fmt.Sprintf("%s = %d", strings.Join(parts, ", "), sum(rolls))
+}
+
+var detailedDice = standardBehavior("clyde.*(roll (?P<count>[0-9]*)d(?P<faces>[0-9]+) showing rolls|detailed (?P<count>[0-9]*)d(?P<faces>[0-9]+))",
+	[]string{"count", "faces"},
+	false,
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		count, faces, err := diceArgs(kvs)
+		if err != nil {
+			return diceError(err)
+		}
+		if faces == 0 || count == 0 {
+			return "0"
+		}
+		return formatRolls(rollDice(c.rng, count, faces))
+	})
+
+// rollAbilityScore rolls 4d6 and sums the highest three.
+func rollAbilityScore(rng *rand.Rand) int {
+	rolls := rollDice(rng, 4, 6)
+	sort.Ints(rolls)
+	return sum(rolls[1:])
+}
+
+var rollStats = standardBehavior("^clyde.? roll( me)?( some)? (ability scores|stats)",
+	[]string{},
+	false,
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		var scores []string
+		for i := 0; i < 6; i++ {
+			scores = append(scores, strconv.Itoa(rollAbilityScore(c.rng)))
+		}
+		return strings.Join(scores, ", ")
+	})
+
+// dice only fires on a whole dice token that's either the entire
+// message or follows "roll" or Clyde's name, so that dice-like tokens
+// in ordinary conversation (addresses, part numbers) don't trigger it.
+var dice = standardBehavior("^(?P<count>[0-9]*)d(?P<faces>[0-9]+)[\\.!\\?]*$|(^clyde\\S*|(\\s|^)roll)\\s+(?P<count>[0-9]*)d(?P<faces>[0-9]+)([\\s\\.,!\\?]|$)",
 	[]string{"count", "faces"},
 	false,
 	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
-		var count int
-		if kvs["count"] == "" {
-			count = 1
-		} else {
-			count, _ = strconv.Atoi(kvs["count"])
+		count, faces, err := diceArgs(kvs)
+		if err != nil {
+			return diceError(err)
 		}
-		faces, _ := strconv.Atoi(kvs["faces"])
 		if faces == 0 {
 			return "0"
 		}
-		total := 0
-		for i := 0; i < count; i++ {
-			total += rand.Intn(faces) + 1
-		}
-		return strconv.Itoa(total)
-	})
-
-var simpleQuips = map[string]string{
-	"wacky": "Aw, and me without my spork.",
-	"too many secrets": "Setec Astronomy",
-	"manna manna": "Do do dit do do.",
-	"growl for me": "Grrrrrr",
-	"(^| )(are|am) not[ ,\\.\\?!]": "Are too!",
-	"(^| )(are|am) too[ ,\\.\\?!]": "Am not!",
-	"i've been captured": "Yay!",
-	"no, that's a bad thing": "Yay!",
-	"morse": "dit, dit dah dah",
-	"(^| )sing\\b": "la la la",
-	"what makes the grass grow": "Fertilizer, sir!",
-	"what is the meaning of life\\?": "42/3",
-	"what do you want\\?": "Never ask that question.",
-	"is there a god\\?": "There is now...",
-	"elvis|bermuda triangle": "Elvis needs boats!!",
-	"brains": "BRAAAAAAAAIIIIINNNNSSSSSS",
-	"bonfire": "Bonfire is not a hivemind.",
-	"(^| )los(e|t|ing) [^ ]+ way": "Don't lose your way!",
-	"contract": "／人◕ ‿‿ ◕人＼",
-	"clyde(::|\\.)(pet|play|cuddle|s[ck]rit?ch|treat|scoop|deposit)": "clyde climbs on top of the bookshelf and hisses",
-}
-
-var fileQuips = map[string]string{
-	"(^| )ai[ ,\\.\\?]": "ai",
-	"[\\*:](tickles?|poke)[\\*:]": "tickle",
-	"what('| i)s wrong\\?": "wrong",
-	"clyde.*thank(s| you)|thank(s| you).*clyde": "welcome",
-	"bye": "bye",
-	"(good ?|')night": "night",
-	"how do you like": "howlike",
-	"(^| )(hi|hello)[ ,\\.\\?!]": "hello",
-	"pull!": "pull",
+		return strconv.Itoa(sum(rollDice(c.rng, count, faces)))
+	})
+
+// quipPair pairs a quip's trigger pattern with its response.
+type quipPair struct {
+	pattern string
+	response string
+}
+
+// simpleQuips is a list of patterns and fixed responses; quip uses
+// the first pattern that matches, so more specific patterns should
+// come before broader ones.
+var simpleQuips = []quipPair{
+	{"wacky", "Aw, and me without my spork."},
+	{"too many secrets", "Setec Astronomy"},
+	{"manna manna", "Do do dit do do."},
+	{"growl for me", "Grrrrrr"},
+	{"(^| )(are|am) not[ ,\\.\\?!]", "Are too!"},
+	{"(^| )(are|am) too[ ,\\.\\?!]", "Am not!"},
+	{"i've been captured", "Yay!"},
+	{"no, that's a bad thing", "Yay!"},
+	{"morse", "dit, dit dah dah"},
+	{"what makes the grass grow", "Fertilizer, sir!"},
+	{"what is the meaning of life\\?", "42/3"},
+	{"what do you want\\?", "Never ask that question."},
+	{"is there a god\\?", "There is now..."},
+	{"elvis|bermuda triangle", "Elvis needs boats!!"},
+	{"brains", "BRAAAAAAAAIIIIINNNNSSSSSS"},
+	{"bonfire", "Bonfire is not a hivemind."},
+	{"(^| )los(e|t|ing) [^ ]+ way", "Don't lose your way!"},
+	{"contract", "／人◕ ‿‿ ◕人＼"},
+	{"clyde(::|\\.)(pet|play|cuddle|s[ck]rit?ch|treat|scoop|deposit)", "clyde climbs on top of the bookshelf and hisses"},
+	{"(^| )sing\\b", "la la la"},
+}
+
+// fileQuips is a list of patterns and files in Clyde's home directory
+// from which to draw a random response, checked in order after
+// simpleQuips.
+var fileQuips = []quipPair{
+	{"(^| )ai[ ,\\.\\?]", "ai"},
+	{"[\\*:](tickles?|poke)[\\*:]", "tickle"},
+	{"what('| i)s wrong\\?", "wrong"},
+	{"clyde.*thank(s| you)|thank(s| you).*clyde", "welcome"},
+	{"bye", "bye"},
+	{"(good ?|')night", "night"},
+	{"how do you like", "howlike"},
+	{"(^| )(hi|hello)[ ,\\.\\?!]", "hello"},
+	{"pull!", "pull"},
 }
 
 func quip(c *Clyde, r zephyr.MessageReaderResult) bool {
-	for k,v := range simpleQuips {
-		if standardBehavior(k, []string{}, false,
+	for _, q := range simpleQuips {
+		response := q.response
+		if standardBehavior(q.pattern, []string{}, false,
 			func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
-				return v
+				return response
 			})(c, r) {
 				return true
 			}
 	}
 
-	for k,v := range fileQuips {
-		if standardBehavior(k, []string{}, false,
+	for _, q := range fileQuips {
+		filename := q.response
+		if standardBehavior(q.pattern, []string{}, false,
 			func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
-				resp, _ := randomLine(c, v)
+				resp, _ := randomLine(c, filename)
 				return resp
 			})(c, r) {
 				return true
@@ -543,15 +1362,145 @@ func quip(c *Clyde, r zephyr.MessageReaderResult) bool {
 	return false
 }
 
+// timeZoneAliases maps some place names to their time zones.
+var timeZoneAliases = map[string]string{
+	"mit": "America/New_York",
+	"boston": "America/New_York",
+	"cambridge": "America/New_York",
+	"new york": "America/New_York",
+	"chicago": "America/Chicago",
+	"denver": "America/Denver",
+	"california": "America/Los_Angeles",
+	"san francisco": "America/Los_Angeles",
+	"seattle": "America/Los_Angeles",
+	"london": "Europe/London",
+	"paris": "Europe/Paris",
+	"berlin": "Europe/Berlin",
+	"tokyo": "Asia/Tokyo",
+	"utc": "UTC",
+}
+
+// timeZone returns the time zone for a place, either from Clyde's
+// configured or built-in aliases or as an IANA time zone name.
+func timeZone(c *Clyde, place string) (*time.Location, error) {
+	name, ok := c.config.TimeZoneAliases[strings.ToLower(place)]
+	if !ok {
+		name, ok = timeZoneAliases[strings.ToLower(place)]
+	}
+	if !ok {
+		name = place
+	}
+	return time.LoadLocation(name)
+}
+
+var clock = standardBehavior("what time is it( in (?P<place>[^\\?]+?))?\\??$",
+	[]string{"place"},
+	false,
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		loc := time.Local
+		if c.config.TimeZone != "" {
+			zone, err := time.LoadLocation(c.config.TimeZone)
+			if err != nil {
+				c.log.Warnf("Bad TimeZone %q: %v", c.config.TimeZone, err)
+			} else {
+				loc = zone
+			}
+		}
+		now := c.clock.Now()
+
+		if kvs["place"] == "" {
+			return fmt.Sprintf("It's %s.", now.In(loc).Format(clockFormat))
+		}
+
+		placeLoc, err := timeZone(c, kvs["place"])
+		if err != nil {
+			return fmt.Sprintf("I don't know where %s is, but here it's %s.", kvs["place"], now.In(loc).Format(clockFormat))
+		}
+		return fmt.Sprintf("It's %s in %s.", now.In(placeLoc).Format(clockFormat), kvs["place"])
+	})
+
+// clockFormat is the format Clyde uses to tell the time.
+const clockFormat = "3:04 PM MST on Monday"
+
+var pigLatin = standardBehavior("^clyde.? (say (this |that )?in )?pig latin:? (?P<phrase>.+)$",
+	[]string{"phrase"},
+	false,
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		return stringutil.PigLatin(kvs["phrase"])
+	})
+
+var reverse = standardBehavior("^clyde.? reverse:?( (?P<text>.*))?$",
+	[]string{"text"},
+	false,
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		text := strings.TrimSpace(kvs["text"])
+		if text == "" {
+			return "Reverse what?"
+		}
+		return stringutil.Reverse(text)
+	})
+
+var echo = standardBehavior("^clyde.? echo (?P<text>.+)$",
+	[]string{"text"},
+	false,
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		return decorateForMood(kvs["text"], c.mood, func(s string) string { return s })
+	})
+
+var repeat = standardBehavior("^clyde.? repeat after me[:,]?(?P<text>.*)$",
+	[]string{"text"},
+	false,
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		text := strings.TrimSpace(kvs["text"])
+		if text == "" {
+			return "Repeat what?"
+		}
+		return text
+	})
+
+var newZsig = standardBehavior("^clyde.? (?P<adopt>use )?(a )?new zsig\\.?$",
+	[]string{"adopt"},
+	false,
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		zsig := c.generateZsig()
+		if kvs["adopt"] == "" {
+			return fmt.Sprintf("How about \"%s\"?", zsig)
+		}
+		c.currentZsig = zsig
+		c.lastZsigRotation = c.clock.Now()
+		return fmt.Sprintf("From now on I'm \"%s\".", zsig)
+	})
+
 var memSize = standardBehavior("how big is your memory", []string{}, false,
 	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
-		size := c.chain.Size()
+		chain, ok := c.chain.(*markov.Chain)
+		if !ok {
+			return "I don't really know how to measure it."
+		}
+		size := chain.Size()
 		return fmt.Sprintf("I've got %d n-gram prefixes in my memory!", size)
 	})
 
+var knowledge = standardBehavior("^clyde.? how much do you know\\??$", []string{}, false,
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		chain, ok := c.chain.(*markov.Chain)
+		if !ok {
+			return "More than you'd think!"
+		}
+		stats := chain.Stats()
+		if stats.Prefixes == 0 {
+			return "Nothing yet! Talk to me more :)"
+		}
+		return fmt.Sprintf("I know %d phrases, and %d ways to keep talking after them!", stats.Prefixes, stats.Suffixes)
+	})
+
 var chainStats = standardBehavior("how('s| is) your chainer", []string{}, false,
 	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
-		stats := c.chain.Stats()
+		chain, ok := c.chain.(*markov.Chain)
+		if !ok {
+			return "I'm not using a chainer right now."
+		}
+		stats := chain.Usage()
 		total := 0
 		for _, count := range stats {
 			total += count
@@ -572,14 +1521,239 @@ var chainStats = standardBehavior("how('s| is) your chainer", []string{}, false,
 		return strings.Join(replyParts, ", ")
 	})
 
+const factsFile = "facts.json"
+
+// factKey normalizes the subject of a fact so that "The answer" and
+// "answer" refer to the same fact.
+func factKey(key string) string {
+	key = strings.ToLower(strings.TrimSpace(key))
+	return strings.TrimPrefix(key, "the ")
+}
+
+// loadFacts returns Clyde's learned facts, or an empty map if none
+// have been saved yet.
+func loadFacts(c *Clyde) map[string]string {
+	facts := make(map[string]string)
+	err := loadJSON(c, factsFile, &facts)
+	if err != nil && !os.IsNotExist(err) {
+		c.log.Errorf("Error loading facts: %v", err)
+	}
+	return facts
+}
+
+var babble = standardBehavior("^clyde.? (say something|talk to me)",
+	[]string{},
+	false,
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		if s, ok := c.chain.(sizedGenerator); ok && s.Size() == 0 {
+			return "I don't have much to say yet."
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), generateTimeout)
+		defer cancel()
+		return generateCtx(ctx, c.chain, "", sentenceCounts[c.rng.Intn(len(sentenceCounts))], maxWords)
+	})
+
+var calc = standardBehavior("^clyde.? what('s| is) (?P<expr>[-0-9 \\+\\*/\\(\\)]*[0-9][-0-9 \\+\\*/\\(\\)]*?) *\\??$",
+	[]string{"expr"},
+	false,
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		value, err := evalArithmetic(kvs["expr"])
+		switch err {
+		case nil:
+			return fmt.Sprintf("%s is %d.", strings.TrimSpace(kvs["expr"]), value)
+		case errDivideByZero:
+			return "You can't divide by zero, silly!"
+		case errOverflow:
+			return "That number's too big for me to count!"
+		default:
+			return "That doesn't look like math to me..."
+		}
+	})
+
+var learnFact = standardBehavior("^clyde.? remember( that)? (?P<key>[^\\?]+?) is (?P<value>[^\\?]+?)\\.?$",
+	[]string{"key", "value"},
+	false,
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		facts := loadFacts(c)
+		facts[factKey(kvs["key"])] = kvs["value"]
+		saveJSON(c, factsFile, facts)
+		return "Got it!"
+	})
+
+var recallFact = standardBehavior("^clyde.? what('s| is) (?P<key>[^\\?]+?)\\??$",
+	[]string{"key"},
+	false,
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		value, ok := loadFacts(c)[factKey(kvs["key"])]
+		if !ok {
+			return fmt.Sprintf("I don't know what %s is.", kvs["key"])
+		}
+		return fmt.Sprintf("%s is %s.", stringutil.Capitalize(kvs["key"]), value)
+	})
+
+// dictionaryFile holds the definitions Clyde has been taught, as a
+// JSON object mapping lowercased words to their definitions.
+const dictionaryFile = "dictionary.json"
+
+// loadDictionary returns Clyde's dictionary, or an empty one if no
+// definitions have been saved yet.
+func loadDictionary(c *Clyde) map[string]string {
+	dict := make(map[string]string)
+	err := loadJSON(c, dictionaryFile, &dict)
+	if err != nil && !os.IsNotExist(err) {
+		c.log.Errorf("Error loading dictionary: %v", err)
+	}
+	return dict
+}
+
+var define = standardBehavior("^clyde.? define (?P<word>[^\\?]+?)\\??$",
+	[]string{"word"},
+	false,
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		definition, ok := loadDictionary(c)[strings.ToLower(kvs["word"])]
+		if !ok {
+			return "I don't know that word."
+		}
+		return fmt.Sprintf("%s: %s", kvs["word"], definition)
+	})
+
+var learnDefinition = standardBehavior("^clyde.? (?P<word>[^ ]+) means (?P<definition>.+?)\\.?$",
+	[]string{"word", "definition"},
+	false,
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		dict := loadDictionary(c)
+		dict[strings.ToLower(kvs["word"])] = kvs["definition"]
+		saveJSON(c, dictionaryFile, dict)
+		return "Got it!"
+	})
+
+var help = standardBehavior("^clyde.? help\\??$", []string{}, false,
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		var helps []string
+		for _, b := range behaviors {
+			if b.help != "" {
+				helps = append(helps, b.help)
+			}
+		}
+		return stringutil.BreakLines(fmt.Sprintf("Try saying \"clyde, ...\" followed by: %s", strings.Join(helps, "; ")), stringutil.MaxLine)
+	})
+
+var why = standardBehavior("^clyde.? why did you (say|do) that\\??$", []string{}, false,
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		if c.lastTriggered.name == "" {
+			return "I haven't done anything yet."
+		}
+		if c.lastTriggered.pattern == "" {
+			return fmt.Sprintf("That was my %s behavior.", c.lastTriggered.name)
+		}
+		return fmt.Sprintf("That was my %s behavior, matching %s.", c.lastTriggered.name, c.lastTriggered.pattern)
+	})
+
+// humanizeDuration formats a duration as a list of days, hours, and
+// minutes, e.g. "2 days, 1 hour, and 5 minutes".
+func humanizeDuration(d time.Duration) string {
+	if d < time.Minute {
+		return "less than a minute"
+	}
+
+	units := []struct {
+		name string
+		size time.Duration
+	}{
+		{"day", 24*time.Hour},
+		{"hour", time.Hour},
+		{"minute", time.Minute},
+	}
+	var parts []string
+	for _, u := range units {
+		n := int(d / u.size)
+		d -= time.Duration(n) * u.size
+		switch {
+		case n == 1:
+			parts = append(parts, fmt.Sprintf("1 %s", u.name))
+		case n > 1:
+			parts = append(parts, fmt.Sprintf("%d %ss", n, u.name))
+		}
+	}
+
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	last := len(parts)-1
+	parts[last] = "and " + parts[last]
+	if len(parts) == 2 {
+		return strings.Join(parts, " ")
+	}
+	return strings.Join(parts, ", ")
+}
+
+var status = standardBehavior("^clyde.? (how long have you been (running|up|awake)|uptime|status|what version are you( running)?)\\??$", []string{}, false,
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		subCount := 0
+		for _, sub := range c.subs {
+			if sub.Policy != 0 {
+				subCount++
+			}
+		}
+		return fmt.Sprintf("I've been running for %s (version %s). I'm %s, and I'm subbed to %d classes besides my homes.",
+			humanizeDuration(c.since(c.startTime)), Version, c.mood.String(), subCount)
+	})
+
+var forgetEverything = standardBehavior("^clyde,? forget everything[\\.!]*$", []string{}, false,
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		if r.AuthStatus != zephyr.AuthYes || !c.config.isAdmin(shortSender(r)) {
+			return "You look sketchy, I don't trust you..."
+		}
+
+		chain, ok := c.chain.(resettableGenerator)
+		zsigChain, zsigOk := c.zsigChain.(resettableGenerator)
+		if !ok || !zsigOk {
+			return "I don't know how to forget things."
+		}
+
+		// Behaviors run on Clyde's event loop, which is the only
+		// place the chains are used, so they can be reset here
+		// safely
+		chain.Reset()
+		zsigChain.Reset()
+		c.log.Warnf("Chains reset by %s", r.Message.Header.Sender)
+		return "Wait, who am I? Where am I? What's a zephyr?"
+	})
+
+var setPrefixLen = standardBehavior("^clyde.? use (?P<n>[0-9]+)[- ]word prefixes[\\.!]*$", []string{"n"}, false,
+	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
+		if r.AuthStatus != zephyr.AuthYes || !c.config.isAdmin(shortSender(r)) {
+			return "You look sketchy, I don't trust you..."
+		}
+
+		n, err := strconv.Atoi(kvs["n"])
+		if err != nil || n < 1 || n > maxPrefixLen {
+			return fmt.Sprintf("I can only use prefixes of 1 to %d words.", maxPrefixLen)
+		}
+		if n == c.config.PrefixLen {
+			return "That's what I'm already doing!"
+		}
+
+		err = c.setPrefixLen(n)
+		if err != nil {
+			return "I can't change how I think right now."
+		}
+		c.config.PrefixLen = n
+		err = c.saveConfig("PrefixLen")
+		if err != nil {
+			c.log.Errorf("Error saving config: %v", err)
+		}
+		return fmt.Sprintf("Ok, now I'm thinking %d words at a time.", n)
+	})
+
 var ping = standardBehavior("^clyde\\?$", []string{}, false,
 	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
 		return "Yes?"
 	})
 
-var chat = standardBehavior("clyde,? (tell me about )?(?P<topic>[^ ]+)",
+var chat = perSenderCooldown("chat", standardBehavior("clyde,? (tell me about )?(?P<topic>[^ ]+)",
 	[]string{"topic"},
 	true,
 	func(c *Clyde, r zephyr.MessageReaderResult, kvs map[string]string) string {
 		return stringutil.Capitalize(kvs["topic"])
-	})
+	}))
@@ -0,0 +1,1472 @@
+// Copyright 2016 Sam Dukhovni <dukhovni@mit.edu>
+//
+// Licensed under the MIT License
+// (https://opensource.org/licenses/MIT)
+
+package clyde
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"github.com/zephyr-im/zephyr-go"
+	"github.com/sdukhovni/clyde-go/cat"
+	"github.com/sdukhovni/clyde-go/markov"
+	"github.com/sdukhovni/clyde-go/mood"
+	"github.com/sdukhovni/clyde-go/stringutil"
+)
+
+func TestRollDice(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	rolls := rollDice(rng, 50, 6)
+	if len(rolls) != 50 {
+		t.Fatalf("got %d rolls, want 50", len(rolls))
+	}
+	for _, roll := range rolls {
+		if roll < 1 || roll > 6 {
+			t.Errorf("rolled %d on a d6", roll)
+		}
+	}
+	if rolls := rollDice(rng, 3, 0); len(rolls) != 0 {
+		t.Errorf("rolled %v on a d0, want nothing", rolls)
+	}
+}
+
+func TestFormatRolls(t *testing.T) {
+	tests := []struct {
+		rolls []int
+		want string
+	}{
+		{[]int{3, 5, 1, 6}, "3, 5, 1, 6 = 15"},
+		{[]int{4}, "4 = 4"},
+	}
+	for _, test := range tests {
+		if got := formatRolls(test.rolls); got != test.want {
+			t.Errorf("formatRolls(%v) = %q, want %q", test.rolls, got, test.want)
+		}
+	}
+}
+
+func TestFactKey(t *testing.T) {
+	for _, key := range []string{"answer", "the answer", "The Answer", "  answer "} {
+		if got := factKey(key); got != "answer" {
+			t.Errorf("factKey(%q) = %q, want \"answer\"", key, got)
+		}
+	}
+}
+
+func TestJSONFiles(t *testing.T) {
+	c := &Clyde{homeDir: t.TempDir()}
+	facts := map[string]string{"answer": "42"}
+	if err := saveJSON(c, factsFile, facts); err != nil {
+		t.Fatal(err)
+	}
+	loaded := make(map[string]string)
+	if err := loadJSON(c, factsFile, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, facts) {
+		t.Errorf("loaded %v, want %v", loaded, facts)
+	}
+}
+
+func TestOptionSeparator(t *testing.T) {
+	tests := []struct {
+		options string
+		want []string
+	}{
+		{"tea or coffee", []string{"tea", "coffee"}},
+		{"red, green, or blue", []string{"red", "green", "blue"}},
+		{"red,green OR blue", []string{"red", "green", "blue"}},
+	}
+	for _, test := range tests {
+		if got := optionSeparator.Split(test.options, -1); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("split %q into %q, want %q", test.options, got, test.want)
+		}
+	}
+}
+
+func TestQuipPatterns(t *testing.T) {
+	for _, q := range append(simpleQuips, fileQuips...) {
+		if _, err := regexp.Compile("(?i)" + q.pattern); err != nil {
+			t.Errorf("quip pattern %q: %v", q.pattern, err)
+		}
+	}
+
+	// The first matching simple quip wins
+	tests := []struct {
+		body, want string
+	}{
+		{"brains are wacky, ask elvis", "Aw, and me without my spork."},
+		{"we are not amused", "Are too!"},
+	}
+	for _, test := range tests {
+		got := ""
+		for _, q := range simpleQuips {
+			if regexp.MustCompile("(?i)" + q.pattern).MatchString(test.body) {
+				got = q.response
+				break
+			}
+		}
+		if got != test.want {
+			t.Errorf("%q: first quip is %q, want %q", test.body, got, test.want)
+		}
+	}
+}
+
+func TestActLikeFile(t *testing.T) {
+	if got := actLikeFile("Ben Bitdiddle"); got != "al/ben bitdiddle" {
+		t.Errorf("actLikeFile(%q) = %q", "Ben Bitdiddle", got)
+	}
+	for _, person := range []string{"../config.json", "a/b", "/"} {
+		if got := actLikeFile(person); path.Dir(got) != "al" {
+			t.Errorf("actLikeFile(%q) = %q, outside the act-like directory", person, got)
+		}
+	}
+}
+
+func TestHasLine(t *testing.T) {
+	c := &Clyde{homeDir: t.TempDir()}
+	if hasLine(c, "lines", "I broke it") {
+		t.Error("a missing file has lines")
+	}
+	addLine(c, "lines", "I broke it")
+	for _, line := range []string{"I broke it", "i BROKE it"} {
+		if !hasLine(c, "lines", line) {
+			t.Errorf("hasLine(%q) = false", line)
+		}
+	}
+	if hasLine(c, "lines", "it works now") {
+		t.Error("hasLine matched a line that isn't there")
+	}
+}
+
+func TestTimeZone(t *testing.T) {
+	c := &Clyde{config: Config{TimeZoneAliases: map[string]string{"the office": "America/New_York"}}}
+	tests := []struct {
+		place, want string
+	}{
+		{"Tokyo", "Asia/Tokyo"},
+		{"the Office", "America/New_York"},
+		{"Europe/Paris", "Europe/Paris"},
+	}
+	for _, test := range tests {
+		loc, err := timeZone(c, test.place)
+		if err != nil {
+			t.Errorf("timeZone(%q) returned %v", test.place, err)
+		} else if loc.String() != test.want {
+			t.Errorf("timeZone(%q) = %v, want %v", test.place, loc, test.want)
+		}
+	}
+	if _, err := timeZone(c, "Narnia"); err == nil {
+		t.Error("found a time zone for Narnia")
+	}
+}
+
+func TestRandomLine(t *testing.T) {
+	c := &Clyde{homeDir: t.TempDir(), rng: rand.New(rand.NewSource(1))}
+	if err := os.WriteFile(c.path("howlike"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if line, err := randomLine(c, "howlike"); err == nil {
+		t.Errorf("chose %q from an empty file", line)
+	}
+
+	addLine(c, "howlike", "it's so squeaky")
+	if line, err := randomLine(c, "howlike"); err != nil || line != "it's so squeaky" {
+		t.Errorf("randomLine = %q, %v", line, err)
+	}
+}
+
+var detailedRolls = regexp.MustCompile("^([0-9]+(, [0-9]+)*) = ([0-9]+)$")
+
+func TestFacts(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	tests := []struct {
+		body, want string
+	}{
+		{"clyde, what is the moon?", "I don't know what the moon is."},
+		{"clyde, remember the sky is blue", "Got it!"},
+		{"clyde, what is the sky?", "The sky is blue."},
+		{"clyde, remember that the SKY is green.", "Got it!"},
+		{"clyde, what's the sky", "The sky is green."},
+	}
+	for _, test := range tests {
+		if got := reply(t, c, ft, homeMessage(test.body)); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.body, got, test.want)
+		}
+	}
+
+	for _, body := range []string{"clyde, how is it going", "clyde, this is great"} {
+		if learnFact(c, homeMessage(body)) {
+			t.Errorf("%q was learned as a fact", body)
+		}
+	}
+	if facts := loadFacts(c); len(facts) != 1 {
+		t.Errorf("got facts %v, want only the sky", facts)
+	}
+}
+
+func TestKarma(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	if karma(c, homeMessage("pizza++ is great, Tacos++, pizza++ but mondays--. alice++")) {
+		t.Error("karma claimed a message")
+	}
+	karma(c, homeMessage("mondays--"))
+
+	want := map[string]int{"pizza": 2, "tacos": 1, "mondays": -2}
+	scores := loadKarma(c)
+	for thing, score := range want {
+		if scores[thing] != score {
+			t.Errorf("%s has %d karma, want %d", thing, scores[thing], score)
+		}
+	}
+	if _, ok := scores["alice"]; ok {
+		t.Error("a sender changed their own karma")
+	}
+
+	if got := reply(t, c, ft, homeMessage("clyde, karma Pizza?")); got != "pizza has 2 karma." {
+		t.Errorf("karma query: got %q", got)
+	}
+	if got := reply(t, c, ft, homeMessage("clyde, karma nothing")); got != "nothing has 0 karma." {
+		t.Errorf("karma query for unknown thing: got %q", got)
+	}
+}
+
+func TestChoose(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	tests := []struct {
+		body string
+		options []string
+	}{
+		{"clyde, tea or coffee?", []string{"Tea", "Coffee"}},
+		{"clyde, red, green, or blue?", []string{"Red", "Green", "Blue"}},
+	}
+	for _, test := range tests {
+		seen := make(map[string]bool)
+		for i := 0; i < 50; i++ {
+			if !choose(c, homeMessage(test.body)) {
+				t.Fatalf("%q didn't trigger choose", test.body)
+			}
+			seen[ft.sends()[0].body] = true
+		}
+		for _, option := range test.options {
+			if !seen[option] {
+				t.Errorf("%q: never chose %q", test.body, option)
+			}
+			delete(seen, option)
+		}
+		if len(seen) != 0 {
+			t.Errorf("%q: chose unexpected options %v", test.body, seen)
+		}
+	}
+
+	if choose(c, homeMessage("clyde, tea please")) {
+		t.Error("choose triggered without options")
+	}
+}
+
+func TestHelpLines(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	got := reply(t, c, ft, homeMessage("clyde, help?"))
+	if !strings.Contains(strings.Join(strings.Fields(got), " "), "tell me a story") {
+		t.Errorf("help doesn't mention stories: %q", got)
+	}
+	for _, line := range strings.Split(got, "\n") {
+		if len(line) > stringutil.MaxLine {
+			t.Errorf("help line too long: %q", line)
+		}
+	}
+}
+
+func TestQuipOrder(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	tests := []struct {
+		body, want string
+	}{
+		{"brains are wacky, ask elvis", "Aw, and me without my spork."},
+		{"bye, I'm off to sing", "la la la"},
+	}
+	for _, test := range tests {
+		for i := 0; i < 20; i++ {
+			if !quip(c, homeMessage(test.body)) {
+				t.Fatalf("%q didn't trigger quip", test.body)
+			}
+			if got := ft.sends()[0].body; got != test.want {
+				t.Fatalf("%q: got %q, want %q", test.body, got, test.want)
+			}
+		}
+	}
+}
+
+// writeActLike gives Clyde phrases to act like a person, as if he'd
+// been taught them.
+func writeActLike(t *testing.T, c *Clyde, person string, phrases ...string) {
+	t.Helper()
+	err := os.MkdirAll(c.path("al"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, phrase := range phrases {
+		addLine(c, actLikeFile(person), phrase)
+	}
+}
+
+func TestActLikeWho(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	if got := reply(t, c, ft, homeMessage("clyde, who can you act like?")); got != "I can't act like anyone yet." {
+		t.Errorf("with no act-like directory: got %q", got)
+	}
+
+	writeActLike(t, c, "ben bitdiddle", "I broke it")
+	writeActLike(t, c, "a/b", "slashes!")
+	err := os.WriteFile(c.path(path.Join("al", "bad\\q")), []byte("x\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "I can act like a/b, ben bitdiddle."
+	if got := reply(t, c, ft, homeMessage("clyde, who do you imitate?")); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestForgetActLike(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "{}")
+	writeActLike(t, c, "ben", "I broke it")
+
+	r := homeMessage("clyde, forget how to act like ben")
+	r.AuthStatus = zephyr.AuthNo
+	if got := reply(t, c, ft, r); got != "You look sketchy, I don't trust you..." {
+		t.Errorf("unauthenticated: got %q", got)
+	}
+	if _, err := os.Stat(c.path(actLikeFile("ben"))); err != nil {
+		t.Errorf("unauthenticated forget removed the file: %v", err)
+	}
+
+	if got := reply(t, c, ft, homeMessage("clyde, forget how to act like Ben.")); got != "Ok, I've forgotten how to act like Ben." {
+		t.Errorf("got %q", got)
+	}
+	if _, err := os.Stat(c.path(actLikeFile("ben"))); !os.IsNotExist(err) {
+		t.Errorf("act-like file still exists: %v", err)
+	}
+
+	for _, person := range []string{"ben", "../" + configFile} {
+		want := "I don't know how to act like " + person + " anyway."
+		if got := reply(t, c, ft, homeMessage("clyde, forget how to act like "+person)); got != want {
+			t.Errorf("%s: got %q, want %q", person, got, want)
+		}
+	}
+	if _, err := os.Stat(c.path(configFile)); err != nil {
+		t.Errorf("forgot something outside the act-like directory: %v", err)
+	}
+}
+
+func TestAddActLikeDedupe(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	tests := []struct {
+		body, want string
+	}{
+		{"clyde, ben says \"I broke it\"", "Ok!"},
+		{"clyde, ben says \"I broke it\"", "I already knew that!"},
+		{"clyde, Ben says 'i BROKE it'", "I already knew that!"},
+		{"clyde, ben says it works now", "Ok!"},
+	}
+	for _, test := range tests {
+		if got := reply(t, c, ft, homeMessage(test.body)); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.body, got, test.want)
+		}
+	}
+
+	lines, err := allLines(c, actLikeFile("ben"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"I broke it", "it works now"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("act-like file holds %q, want %q", lines, want)
+	}
+}
+
+func TestPigLatinBehavior(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	if got := reply(t, c, ft, homeMessage("clyde, say that in pig latin: Hello, world!")); got != "Ellohay, orldway!" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestEmpathyShouting(t *testing.T) {
+	tests := []struct {
+		body string
+		want mood.Mood
+	}{
+		{"CLYDE, WHY WON'T YOU LISTEN", mood.Ok.Worse()},
+		{"clyde, NASA", mood.Ok},
+		{"Clyde, Why Won't You Listen", mood.Ok},
+		{"WHY WON'T ANYONE LISTEN", mood.Ok},
+	}
+	for _, test := range tests {
+		c, _, _ := newTestClyde(t, "")
+		empathy(c, homeMessage(test.body))
+		if c.mood != test.want {
+			t.Errorf("%q: mood is %v, want %v", test.body, c.mood, test.want)
+		}
+	}
+}
+
+// catMessage returns a zephyr from the cat on class cats.
+func catMessage(name, body string) zephyr.MessageReaderResult {
+	return message(name, "cats", "lounge", body)
+}
+
+func TestCatPlaySuccess(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	c.cat.State = cat.TryPlay
+	c.cat.LastPlayCmd = "treat"
+	if !watchCat(c, catMessage(cat.CatName, "zeroday rubs up against clyde")) {
+		t.Error("watchCat didn't claim the cat playing with Clyde")
+	}
+	if c.cat.PlaySuccesses["treat"] != 1 {
+		t.Errorf("PlaySuccesses is %v after a successful treat", c.cat.PlaySuccesses)
+	}
+	if c.cat.State != cat.Normal || c.mood != mood.Ok.BetterBy(2) {
+		t.Errorf("cat is %v and mood is %v after playing", c.cat.State, c.mood)
+	}
+
+	// Someone else playing with the cat isn't a success
+	c.cat.State = cat.TryPlay
+	watchCat(c, catMessage(cat.CatName, "zeroday bats at bob"))
+	if c.cat.PlaySuccesses["treat"] != 1 {
+		t.Errorf("PlaySuccesses is %v after the cat played with bob", c.cat.PlaySuccesses)
+	}
+	if sent := ft.sends(); len(sent) != 0 {
+		t.Errorf("sent %v", sent)
+	}
+}
+
+func TestTryPlayCatWeighted(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	c.cat.Class, c.cat.Instance = "cats", "lounge"
+	c.cat.PlaySuccesses = map[string]int{"boop": 1000}
+	tryPlayCat(c)
+	sent := ft.sends()
+	if len(sent) != 1 || sent[0].body != "zeroday::boop" || sent[0].class != "cats" {
+		t.Errorf("sent %v, want a boop", sent)
+	}
+	if c.cat.State != cat.TryPlay || c.cat.LastPlayCmd != "boop" {
+		t.Errorf("cat is %v after %q", c.cat.State, c.cat.LastPlayCmd)
+	}
+}
+
+func TestPerSenderCooldownUnclaimed(t *testing.T) {
+	c, _, _ := newTestClyde(t, `{"SenderCooldown": "1m"}`)
+	claim := false
+	b := perSenderCooldown("test", func(c *Clyde, r zephyr.MessageReaderResult) bool {
+		return claim
+	})
+	alice := homeMessage("hi")
+	b(c, alice)
+	claim = true
+	if !b(c, alice) {
+		t.Error("a message the behavior didn't handle started a cooldown")
+	}
+}
+
+func TestBabble(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	if !babble(c, homeMessage("clyde, say something")) {
+		t.Fatal("babble didn't handle \"say something\"")
+	}
+	if sent := ft.sends(); len(sent) != 1 || sent[0].body != "I don't have much to say yet." {
+		t.Errorf("with an empty chain, sent %v", sent)
+	}
+
+	c.learn(homeMessage("the cat sat on the mat. the dog sat on the log."))
+	if !babble(c, homeMessage("Clyde, talk to me")) {
+		t.Fatal("babble didn't handle \"talk to me\"")
+	}
+	sent := ft.sends()
+	if len(sent) != 1 || !strings.Contains(sent[0].body, " sat on the ") {
+		t.Errorf("with a seeded chain, sent %v", sent)
+	}
+}
+
+func TestHumanizeDuration(t *testing.T) {
+	tests := []struct {
+		d time.Duration
+		want string
+	}{
+		{30 * time.Second, "less than a minute"},
+		{time.Minute, "1 minute"},
+		{2 * time.Hour, "2 hours"},
+		{time.Hour + 5*time.Minute, "1 hour and 5 minutes"},
+		{49*time.Hour + 5*time.Minute, "2 days, 1 hour, and 5 minutes"},
+		{24*time.Hour + 59*time.Second, "1 day"},
+	}
+	for _, test := range tests {
+		if got := humanizeDuration(test.d); got != test.want {
+			t.Errorf("humanizeDuration(%v) = %q, want %q", test.d, got, test.want)
+		}
+	}
+}
+
+func TestChainedCapitalized(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	c.learn(homeMessage("cats are great. i like cats. cats like me."))
+	got := reply(t, c, ft, homeMessage("clyde, cats"))
+	if !strings.HasPrefix(got, "Cats ") || strings.Contains(got, " i ") {
+		t.Errorf("got %q, want capitalized sentences", got)
+	}
+}
+
+func TestForgetEverything(t *testing.T) {
+	c, ft, _ := newTestClyde(t, `{"AdminSenders": ["alice"]}`)
+	c.learn(homeMessage("the cat sat on the mat."))
+	c.zsigChain.Build(strings.NewReader("purple monkey dishwasher"))
+
+	sketchy := homeMessage("clyde, forget everything")
+	sketchy.AuthStatus = zephyr.AuthNo
+	refusals := []zephyr.MessageReaderResult{
+		sketchy,
+		message("mallory", homeClass, homeInstance, "clyde, forget everything"),
+	}
+	for _, r := range refusals {
+		if got := reply(t, c, ft, r); got != "You look sketchy, I don't trust you..." {
+			t.Errorf("got %q", got)
+		}
+		if chainSize(c) == 0 {
+			t.Fatal("forgot everything for an unauthorized sender")
+		}
+	}
+
+	if got := reply(t, c, ft, homeMessage("clyde, forget everything!")); got != "Wait, who am I? Where am I? What's a zephyr?" {
+		t.Errorf("got %q", got)
+	}
+	if chainSize(c) != 0 || c.zsigChain.(sizedGenerator).Size() != 0 {
+		t.Errorf("chains have %d and %d entries after forgetting everything", chainSize(c), c.zsigChain.(sizedGenerator).Size())
+	}
+}
+
+func TestDiceInConversation(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	if dice(c, homeMessage("I sold my old card for 1d and a pint")) {
+		t.Errorf("rolled dice in an ordinary sentence: %v", ft.sends())
+	}
+	if !dice(c, homeMessage("clyde, roll 2d6")) {
+		t.Error("didn't roll for \"clyde, roll 2d6\"")
+	}
+}
+
+func TestDicePattern(t *testing.T) {
+	tests := []struct {
+		body string
+		rolls bool
+	}{
+		{"2d6", true},
+		{"d20", true},
+		{"d20!", true},
+		{"roll 4d8", true},
+		{"let's roll d20, ok?", true},
+		{"clyde, 3d6", true},
+		{"Clyde: roll 2d6", true},
+		{"word3d4thing", false},
+		{"3design", false},
+		{"I live at 33d3 main street", false},
+		{"roll 2d6x", false},
+		{"clyde, what's 2d6 mean?", false},
+	}
+	for _, test := range tests {
+		c, _, _ := newTestClyde(t, "")
+		if got := dice(c, homeMessage(test.body)); got != test.rolls {
+			t.Errorf("%q: rolled %v, want %v", test.body, got, test.rolls)
+		}
+	}
+}
+
+func TestFight(t *testing.T) {
+	tests := []struct {
+		body string
+		fights bool
+	}{
+		{"if a bear and a shark fought, who would win?", true},
+		{"If Batman and Superman had a fight, who'd win?", true},
+		{"who would win a fight between a bear and a shark?", true},
+		{"clyde, what about a duel between Hamilton and Burr?", true},
+		{"what's the distance between Boston and NYC?", false},
+		{"what's the difference between a fight between a bear and a shark?", false},
+		{"what time is the fight between Ali and Frazier?", false},
+		{"what's the road between Boston and NYC?", false},
+		{"if a bear and a shark fought, who would be sad?", false},
+	}
+	for _, test := range tests {
+		c, ft, _ := newTestClyde(t, "")
+		if got := fight(c, homeMessage(test.body)); got != test.fights {
+			t.Errorf("%q: fight %v, want %v", test.body, got, test.fights)
+		}
+		if !test.fights {
+			continue
+		}
+		sent := ft.sends()
+		if len(sent) != 1 || !strings.HasPrefix(sent[0].body, "I think ") || !strings.Contains(sent[0].body, " would win, because") {
+			t.Errorf("%q: sent %v", test.body, sent)
+		}
+	}
+}
+
+func TestCatName(t *testing.T) {
+	c, ft, _ := newTestClyde(t, `{"CatName": "mittens"}`)
+	if watchCat(c, catMessage(cat.CatName, "clyde scoops up zeroday")) {
+		t.Error("watched the wrong cat")
+	}
+	if !watchCat(c, catMessage("mittens", "clyde scoops up mittens")) {
+		t.Error("didn't watch the configured cat")
+	}
+	sent := ft.sends()
+	if len(sent) != 1 || sent[0].body != "Let's go over here, mittens" {
+		t.Errorf("sent %v after scooping the cat", sent)
+	}
+
+	c.cat.State = cat.Normal
+	tryScoopCat(c)
+	if sent := ft.sends(); len(sent) != 1 || sent[0].body != "mittens::scoop" {
+		t.Errorf("sent %v trying to scoop the cat", sent)
+	}
+	if got := reply(t, c, ft, homeMessage("clyde, where's mittens?")); !strings.HasPrefix(got, "mittens is ") {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestWhereCat(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	where := homeMessage("clyde, where's zeroday?")
+	if got := reply(t, c, ft, where); got != "I haven't seen zeroday in a while..." {
+		t.Errorf("never seen: got %q", got)
+	}
+
+	watchCat(c, catMessage(cat.CatName, "zeroday curls up"))
+	if got := reply(t, c, ft, where); got != "zeroday is hanging out on -c cats -i lounge." {
+		t.Errorf("got %q", got)
+	}
+
+	watchCat(c, catMessage(cat.CatName, "zeroday is carried away by bob"))
+	if got := reply(t, c, ft, where); got != "zeroday is traveling; I last saw her on -c cats -i lounge." {
+		t.Errorf("traveling: got %q", got)
+	}
+
+	if got := reply(t, c, ft, homeMessage("clyde, where is mittens")); got != "I haven't seen mittens." {
+		t.Errorf("another cat: got %q", got)
+	}
+}
+
+func TestLearnHowLike(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	if err := os.WriteFile(c.path("howlike"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	noReply(t, c, ft, homeMessage("how do you like cheese?"))
+
+	for i := 0; i < 2; i++ {
+		if got := reply(t, c, ft, homeMessage("clyde, you like cheese because it's so squeaky")); got != "Oh yeah, I do like cheese!" {
+			t.Errorf("got %q", got)
+		}
+	}
+	lines, err := allLines(c, "howlike")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(lines, []string{"it's so squeaky"}) {
+		t.Errorf("howlike has %q", lines)
+	}
+	if got := reply(t, c, ft, homeMessage("how do you like cheese?")); got != "it's so squeaky" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestMute(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	c.subs["fun"] = subscription{Policy: REPLYHOME, Instance: "*"}
+	fun := func(body string) zephyr.MessageReaderResult {
+		return message("alice", "fun", "games", body)
+	}
+
+	sketchy := fun("clyde, stop")
+	sketchy.AuthStatus = zephyr.AuthNo
+	reply(t, c, ft, sketchy)
+	if c.subs["fun"].Policy != REPLYHOME {
+		t.Fatal("muted by an unauthenticated sender")
+	}
+
+	c.handleMessage(fun("clyde, be quiet on here!"))
+	sent := ft.sends()
+	if len(sent) != 1 || sent[0].body != "Sorry! I'll be quiet." || sent[0].class != "fun" {
+		t.Errorf("sent %v when muted", sent)
+	}
+	noReply(t, c, ft, fun("clyde, roll 2d6"))
+	noReply(t, c, ft, fun("clyde, stop"))
+
+	// Muting is saved
+	c2, _, _ := loadTestClyde(t, path.Dir(c.path(subsFile)))
+	if want := (subscription{Policy: LISTEN, Instance: "*", MutedPolicy: REPLYHOME}); c2.subs["fun"] != want {
+		t.Errorf("saved %v, want %v", c2.subs["fun"], want)
+	}
+
+	if got := reply(t, c, ft, fun("clyde, you can talk again")); got != "Yay! I missed you all." {
+		t.Errorf("got %q", got)
+	}
+	if c.subs["fun"].Policy != REPLYHOME || c.subs["fun"].MutedPolicy != 0 {
+		t.Errorf("subscription is %v after unmuting", c.subs["fun"])
+	}
+	reply(t, c, ft, fun("clyde, roll 2d6"))
+	noReply(t, c, ft, fun("clyde, you can talk again"))
+
+	if got := reply(t, c, ft, homeMessage("clyde, stop")); got != "But I live here!" {
+		t.Errorf("at home: got %q", got)
+	}
+}
+
+func TestKarmaLeaders(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	if got := reply(t, c, ft, homeMessage("clyde, top karma")); got != "Nobody has any karma yet!" {
+		t.Errorf("no karma: got %q", got)
+	}
+
+	scores := map[string]int{"pizza": 7, "tacos": 3, "mondays": -4, "naps": 3, "rain": -1, "cats": 12, "bugs": -9}
+	saveJSON(c, karmaFile, scores)
+	if got := reply(t, c, ft, homeMessage("clyde, top karma?")); got != "cats: 12, pizza: 7, naps: 3, tacos: 3, rain: -1" {
+		t.Errorf("top karma: got %q", got)
+	}
+	if got := reply(t, c, ft, homeMessage("Clyde, bottom karma")); got != "bugs: -9, mondays: -4, rain: -1, naps: 3, tacos: 3" {
+		t.Errorf("bottom karma: got %q", got)
+	}
+}
+
+// seqGenerator is a Generator that generates each of its texts in
+// turn after the start, repeating the last one once it runs out.
+type seqGenerator struct {
+	texts []string
+	calls int
+}
+
+func (g *seqGenerator) Build(r io.Reader) {
+}
+
+func (g *seqGenerator) Generate(start string, sentences, maxWords int) string {
+	text := g.texts[len(g.texts)-1]
+	if g.calls < len(g.texts) {
+		text = g.texts[g.calls]
+	}
+	g.calls++
+	return strings.TrimSpace(start + " " + text)
+}
+
+func TestLearnBoredAndPlanets(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	tests := []struct {
+		body, want string
+	}{
+		{"clyde, when you're bored, say is anyone out there?", "Ok, I'll keep that in mind for next time I'm bored."},
+		{"clyde when you are bored say is anyone out there?", "I already say that!"},
+		{"clyde, Pluto is a planet!", "Whoa, I'll have to visit Pluto sometime!"},
+		{"clyde, Pluto is a planet", "I know, Pluto is one of my favorite planets!"},
+		{"clyde, Planet X is a planet", "Whoa, I'll have to visit Planet X sometime!"},
+	}
+	for _, test := range tests {
+		if got := reply(t, c, ft, homeMessage(test.body)); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.body, got, test.want)
+		}
+	}
+
+	if lines, _ := allLines(c, "bored"); !reflect.DeepEqual(lines, []string{"is anyone out there?"}) {
+		t.Errorf("bored has %q", lines)
+	}
+	if lines, _ := allLines(c, "planets"); !reflect.DeepEqual(lines, []string{"Pluto", "Planet X"}) {
+		t.Errorf("planets has %q", lines)
+	}
+}
+
+func TestClock(t *testing.T) {
+	c, ft, _ := newTestClyde(t, `{"TimeZone": "UTC", "TimeZoneAliases": {"the office": "America/New_York"}}`)
+	tests := []struct {
+		body, want string
+	}{
+		{"clyde, what time is it?", "It's 3:09 PM UTC on Monday."},
+		{"what time is it in Tokyo?", "It's 12:09 AM JST on Tuesday in Tokyo."},
+		{"what time is it in the office", "It's 11:09 AM EDT on Monday in the office."},
+		{"what time is it in Narnia?", "I don't know where Narnia is, but here it's 3:09 PM UTC on Monday."},
+	}
+	for _, test := range tests {
+		if got := reply(t, c, ft, homeMessage(test.body)); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.body, got, test.want)
+		}
+	}
+}
+
+func TestCatNoAction(t *testing.T) {
+	c, ft, clock := newTestClyde(t, `{"CatBoredAfter": "1m", "CatBoredChance": 1}`)
+	c.lastInteraction = clock.Now().Add(-time.Hour)
+	c.cat.State = cat.TryScoop
+	if watchCat(c, catMessage(cat.CatName, "zeroday is a very good cat")) {
+		t.Error("watchCat claimed an unrecognized cat message")
+	}
+	if c.cat.State != cat.TryScoop {
+		t.Errorf("cat is %v after an unrecognized message, want TryScoop", c.cat.State)
+	}
+	if sent := ft.sends(); len(sent) != 0 {
+		t.Errorf("sent %v after an unrecognized message", sent)
+	}
+
+	watchCat(c, catMessage(cat.CatName, "zeroday curls up"))
+	sent := ft.sends()
+	if len(sent) != 1 || !strings.HasPrefix(sent[0].body, "zeroday::") {
+		t.Fatalf("sent %v when the cat was bored", sent)
+	}
+	if c.cat.State != cat.TryScoop && c.cat.State != cat.TryPlay {
+		t.Errorf("cat is %v after Clyde noticed it was bored", c.cat.State)
+	}
+}
+
+func TestCatBoredNotLonely(t *testing.T) {
+	c, ft, clock := newTestClyde(t, `{"CatBoredAfter": "1h", "CatBoredChance": 1}`)
+	c.lastInteraction = clock.Now()
+	watchCat(c, catMessage(cat.CatName, "zeroday curls up"))
+	if sent := ft.sends(); len(sent) != 0 {
+		t.Errorf("sent %v right after talking to someone", sent)
+	}
+	if c.cat.State != cat.Normal {
+		t.Errorf("cat is %v, want Normal", c.cat.State)
+	}
+}
+
+func TestCatBoredChance(t *testing.T) {
+	tests := []struct {
+		config string
+		interactions int
+	}{
+		{`{"CatBoredAfter": "1m", "CatBoredChance": 1}`, 20},
+		{`{"CatBoredAfter": "1m", "CatBoredChance": 0}`, 0},
+		{`{"CatBoredAfter": "100h", "CatBoredChance": 1}`, 0},
+	}
+	for _, test := range tests {
+		c, ft, clock := newTestClyde(t, test.config)
+		c.lastInteraction = clock.Now().Add(-time.Hour)
+		for i := 0; i < 20; i++ {
+			c.cat.State = cat.Normal
+			watchCat(c, catMessage(cat.CatName, "zeroday mews softly"))
+		}
+		if sent := ft.sends(); len(sent) != test.interactions {
+			t.Errorf("%s: interacted with the bored cat %d times out of 20, want %d", test.config, len(sent), test.interactions)
+		}
+	}
+
+	// By default, Clyde notices the cat is bored about one time in
+	// eight after an hour alone
+	c, ft, clock := newTestClyde(t, "")
+	c.lastInteraction = clock.Now().Add(-2 * time.Hour)
+	for i := 0; i < 800; i++ {
+		c.cat.State = cat.Normal
+		watchCat(c, catMessage(cat.CatName, "zeroday rolls around"))
+	}
+	if n := len(ft.sends()); n < 50 || n > 150 {
+		t.Errorf("with the default config, interacted with the bored cat %d times out of 800, want about 100", n)
+	}
+}
+
+func TestRollAbilityScore(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	total := 0
+	for i := 0; i < 1000; i++ {
+		score := rollAbilityScore(rng)
+		if score < 3 || score > 18 {
+			t.Fatalf("rolled an ability score of %d", score)
+		}
+		total += score
+	}
+	// 4d6 drop lowest averages about 12.24, where 3d6 would average
+	// 10.5
+	if mean := float64(total) / 1000; mean < 11.8 || mean > 12.7 {
+		t.Errorf("ability scores average %.2f, want about 12.24", mean)
+	}
+}
+
+func TestRollStats(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	for _, body := range []string{"clyde, roll stats", "Clyde, roll me some ability scores"} {
+		got := reply(t, c, ft, homeMessage(body))
+		scores := strings.Split(got, ", ")
+		if len(scores) != 6 {
+			t.Fatalf("%q: got %q, want 6 scores", body, got)
+		}
+		for _, s := range scores {
+			if n, err := strconv.Atoi(s); err != nil || n < 3 || n > 18 {
+				t.Errorf("%q: got score %q", body, s)
+			}
+		}
+	}
+	if rollStats(c, homeMessage("clyde, roll 4d6")) {
+		t.Error("rollStats claimed a dice roll")
+	}
+}
+
+func TestHelp(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	never := func(*Clyde, zephyr.MessageReaderResult) bool { return false }
+	saved := behaviors
+	defer func() { behaviors = saved }()
+	behaviors = []registeredBehavior{
+		{"help", help, ""},
+		{"juggle", never, "juggle <things>"},
+		{"secret", never, ""},
+		{"dance", never, "dance"},
+	}
+
+	got := reply(t, c, ft, homeMessage("clyde, help"))
+	got = strings.Join(strings.Fields(got), " ")
+	want := "Try saying \"clyde, ...\" followed by: juggle <things>; dance"
+	if got = unwrap(got); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDefine(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	err := os.WriteFile(c.path(dictionaryFile), []byte(`{"zephyr": "a gentle breeze"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reply(t, c, ft, homeMessage("clyde, define Zephyr?")); got != "Zephyr: a gentle breeze" {
+		t.Errorf("known word: got %q", got)
+	}
+	if got := reply(t, c, ft, homeMessage("clyde, define snark")); got != "I don't know that word." {
+		t.Errorf("unknown word: got %q", got)
+	}
+
+	if got := reply(t, c, ft, homeMessage("clyde, Snark means a kind of boojum.")); got != "Got it!" {
+		t.Errorf("teaching: got %q", got)
+	}
+	if got := reply(t, c, ft, homeMessage("clyde, define snark")); got != "snark: a kind of boojum" {
+		t.Errorf("taught word: got %q", got)
+	}
+	if got := reply(t, c, ft, homeMessage("clyde, define zephyr")); got != "zephyr: a gentle breeze" {
+		t.Errorf("teaching lost a word: got %q", got)
+	}
+}
+
+func TestNewZsig(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	if got := reply(t, c, ft, homeMessage("clyde, new zsig")); got != `How about "Clyde"?` {
+		t.Errorf("with no zsigs learned, got %q", got)
+	}
+
+	c.zsigChain.Build(strings.NewReader("purple monkey dishwasher"))
+	got := reply(t, c, ft, homeMessage("clyde, new zsig"))
+	if !strings.HasPrefix(got, `How about "`) || got == `How about "Clyde"?` || got == `How about ""?` {
+		t.Errorf("with zsigs learned, got %q", got)
+	}
+	if c.currentZsig != "" {
+		t.Errorf("adopted %q without being asked", c.currentZsig)
+	}
+
+	got = reply(t, c, ft, homeMessage("clyde, use a new zsig"))
+	if c.currentZsig == "" || got != fmt.Sprintf("From now on I'm \"%s\".", c.currentZsig) {
+		t.Fatalf("adopting got %q, current zsig %q", got, c.currentZsig)
+	}
+	c.sendHome("hello")
+	if sent := ft.sends(); len(sent) != 1 || sent[0].zsig != c.currentZsig {
+		t.Errorf("sent %v with zsig %q", sent, c.currentZsig)
+	}
+}
+
+func TestPauseLearning(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	unauth := homeMessage("clyde, stop learning")
+	unauth.AuthStatus = zephyr.AuthNo
+	if got := reply(t, c, ft, unauth); got != "You look sketchy, I don't trust you..." {
+		t.Errorf("unauthenticated pause: got %q", got)
+	}
+	if got := reply(t, c, ft, homeMessage("clyde, stop learning")); got != "Ok, I'll stop learning for now." {
+		t.Errorf("pausing: got %q", got)
+	}
+	if got := reply(t, c, ft, homeMessage("clyde, stop learning")); got != "I'm not learning anything right now." {
+		t.Errorf("pausing again: got %q", got)
+	}
+
+	size := chainSize(c)
+	c.handleMessage(homeMessage("the cat sat on the mat."))
+	ft.sends()
+	if chainSize(c) != size {
+		t.Error("learned while paused")
+	}
+	// Pausing is saved with the config
+	loaded, _, _ := loadTestClyde(t, c.homeDir)
+	if !loaded.config.LearningPaused {
+		t.Error("didn't save that learning is paused")
+	}
+
+	if got := reply(t, c, ft, homeMessage("clyde, start learning!")); got != "Ok, I'm learning again!" {
+		t.Errorf("resuming: got %q", got)
+	}
+	c.handleMessage(homeMessage("the cat sat on the mat."))
+	ft.sends()
+	if chainSize(c) == size {
+		t.Error("didn't learn after resuming")
+	}
+}
+
+func TestCheckSub(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	c.subs["fun"] = subscription{Policy: FULL, Instance: "*"}
+	c.subs["games"] = subscription{Policy: REPLYHOMECLASS, Instance: "chess"}
+	tests := []struct {
+		body, want string
+	}{
+		{"clyde, are you on -c fun?", "Yup, I'm subbed to -c fun! It's my favorite class :)"},
+		{"clyde, are you on -c fun -i anything?", "Yup, I'm subbed to -c fun -i anything! It's my favorite instance :)"},
+		{"clyde, are you on -c fun -i *?", "Yup, I'm subbed to -c fun -i *! It's my favorite instance :)"},
+		{"clyde, are you there on -c games -i chess?", "Yup, I'm subbed to -c games -i chess! It's my favorite instance :)"},
+		{"clyde, are you there on -c games -i Chess?", "Yup, I'm subbed to -c games -i Chess! It's my favorite instance :)"},
+		{"clyde, are you on -c games -i go?", "I'm only subbed to -c games -i chess."},
+		{"clyde, are you on -c games -i *?", "I'm only subbed to -c games -i chess."},
+		{"clyde, are you on -c games?", "I'm only subbed to -c games -i chess."},
+		{"clyde, are you on -c boring?", "I'm not subbed to -c boring."},
+		{"clyde, are you on -c boring -i stuff?", "I'm not subbed to -c boring -i stuff."},
+	}
+	for _, test := range tests {
+		if got := reply(t, c, ft, homeMessage(test.body)); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.body, got, test.want)
+		}
+	}
+}
+
+func TestKnowledge(t *testing.T) {
+	c, ft, _ := newTestClyde(t, `{"LearningPaused": true}`)
+	if got := reply(t, c, ft, homeMessage("clyde, how much do you know?")); got != "Nothing yet! Talk to me more :)" {
+		t.Errorf("with an empty chain, got %q", got)
+	}
+
+	c.learn(homeMessage("the cat sat on the mat. the dog sat on the log."))
+	got := reply(t, c, ft, homeMessage("clyde, how much do you know?"))
+	stats := c.chain.(*markov.Chain).Stats()
+	if want := fmt.Sprintf("I know %d phrases, and %d ways to keep talking after them!", stats.Prefixes, stats.Suffixes); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestGenerateMinWords(t *testing.T) {
+	tests := []struct {
+		texts []string
+		want string
+		calls int
+	}{
+		// Rich enough the first time
+		{[]string{"one two three.", "one."}, "Cats one two three.", 1},
+		// Too sparse every time; fall back to the best attempt
+		{[]string{"", "one.", "one two.", "one.", ""}, "Cats one two.", generateAttempts},
+		{[]string{""}, "Cats", generateAttempts},
+		// Rich enough eventually
+		{[]string{"", "one two three four."}, "Cats one two three four.", 2},
+	}
+	for _, test := range tests {
+		c, _, _ := newTestClyde(t, `{"MinChainWords": 3}`)
+		g := &seqGenerator{texts: test.texts}
+		c.SetGenerators(g, &seqGenerator{texts: []string{""}})
+		got := c.generate(context.Background(), "Cats", 1)
+		if got != test.want || g.calls != test.calls {
+			t.Errorf("%q: generated %q in %d tries, want %q in %d", test.texts, got, g.calls, test.want, test.calls)
+		}
+	}
+
+	// Once the context is done, Clyde settles for his first attempt
+	c, _, _ := newTestClyde(t, `{"MinChainWords": 3}`)
+	g := &seqGenerator{texts: []string{"one.", "one two three four."}}
+	c.SetGenerators(g, &seqGenerator{texts: []string{""}})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if got := c.generate(ctx, "Cats", 1); got != "Cats one." || g.calls != 1 {
+		t.Errorf("with a canceled context, generated %q in %d tries", got, g.calls)
+	}
+}
+
+func TestRepeat(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	tests := []struct {
+		body, want string
+	}{
+		{"clyde, repeat after me: hello World", "hello World"},
+		{"Clyde, repeat after me, what is 1+1?", "what is 1+1?"},
+		{"clyde, repeat after me:", "Repeat what?"},
+		{"clyde, repeat after me:   ", "Repeat what?"},
+	}
+	for _, test := range tests {
+		if got := reply(t, c, ft, homeMessage(test.body)); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.body, got, test.want)
+		}
+	}
+
+	long := strings.TrimSpace(strings.Repeat("the quick brown fox jumps over the lazy dog ", 5))
+	got := reply(t, c, ft, homeMessage("clyde, repeat after me: "+long))
+	if !strings.Contains(got, "\n") || unwrap(got) != long {
+		t.Errorf("long repeat: got %q", got)
+	}
+	for _, line := range strings.Split(got, "\n") {
+		if len(line) > stringutil.MaxLine {
+			t.Errorf("line %q is too long", line)
+		}
+	}
+}
+
+func TestDetailedDice(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	for _, body := range []string{"clyde, detailed 3d6", "clyde, roll 3d6 showing rolls"} {
+		got := reply(t, c, ft, homeMessage(body))
+		m := detailedRolls.FindStringSubmatch(got)
+		if m == nil {
+			t.Fatalf("%q: got %q, want detailed rolls", body, got)
+		}
+		rolls := strings.Split(m[1], ", ")
+		if len(rolls) != 3 {
+			t.Errorf("%q: got %d rolls, want 3", body, len(rolls))
+		}
+		total := 0
+		for _, roll := range rolls {
+			n, _ := strconv.Atoi(roll)
+			total += n
+		}
+		if strconv.Itoa(total) != m[3] {
+			t.Errorf("%q: rolls in %q don't add up", body, got)
+		}
+	}
+
+	if got := reply(t, c, ft, homeMessage("clyde, detailed 1000d6")); got != "I don't have that many dice!" {
+		t.Errorf("1000d6: got %q", got)
+	}
+	if got := reply(t, c, ft, homeMessage("clyde, roll 99999999999999999999d6")); got != "Those are some weird dice." {
+		t.Errorf("huge dice count: got %q", got)
+	}
+}
+
+func TestDiceArgs(t *testing.T) {
+	tests := []struct {
+		count, faces string
+		wantCount, wantFaces int
+		wantErr bool
+	}{
+		{"", "20", 1, 20, false},
+		{"3", "6", 3, 6, false},
+		{"100", "6", 100, 6, false},
+		{"101", "6", 0, 0, true},
+		{"99999999999999999999", "6", 0, 0, true},
+		{"2", "99999999999999999999", 0, 0, true},
+	}
+	for _, test := range tests {
+		count, faces, err := diceArgs(map[string]string{"count": test.count, "faces": test.faces})
+		if (err != nil) != test.wantErr || count != test.wantCount || faces != test.wantFaces {
+			t.Errorf("diceArgs(%q, %q) = %d, %d, %v", test.count, test.faces, count, faces, err)
+		}
+	}
+}
+
+func TestPerSenderCooldown(t *testing.T) {
+	c, _, clock := newTestClyde(t, `{"SenderCooldown": "1m"}`)
+	calls := 0
+	b := perSenderCooldown("test", func(c *Clyde, r zephyr.MessageReaderResult) bool {
+		calls++
+		return true
+	})
+	alice := message("alice", homeClass, homeInstance, "clyde, tell me a story")
+	bob := message("bob", homeClass, homeInstance, "clyde, tell me a story")
+
+	if !b(c, alice) {
+		t.Error("alice's first message didn't trigger")
+	}
+	clock.Advance(30 * time.Second)
+	if b(c, alice) {
+		t.Error("alice triggered again during her cooldown")
+	}
+	if !b(c, bob) {
+		t.Error("bob was throttled by alice's cooldown")
+	}
+	if calls != 2 {
+		t.Errorf("wrapped behavior ran %d times, want 2", calls)
+	}
+	clock.Advance(30 * time.Second)
+	if !b(c, alice) {
+		t.Error("alice was still throttled after her cooldown")
+	}
+}
+
+func TestEcho(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	tests := []struct {
+		m mood.Mood
+		want string
+	}{
+		{mood.Yucky, "purple monkey dishwasher"},
+		{mood.Lonely, "purple monkey dishwasher *sigh*"},
+		{mood.Turnip, "blub blub"},
+		{mood.Great, "*bounce* purple monkey dishwasher"},
+	}
+	for _, test := range tests {
+		c.mood = test.m
+		if got := reply(t, c, ft, homeMessage("clyde, echo purple monkey dishwasher")); got != test.want {
+			t.Errorf("%v: got %q, want %q", test.m, got, test.want)
+		}
+	}
+}
+
+func TestQuoteSomeone(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	const nobody = "I don't know what anyone's said yet."
+	if got := reply(t, c, ft, homeMessage("clyde, quote someone")); got != nobody {
+		t.Errorf("with no act-like directory: got %q", got)
+	}
+	if err := os.MkdirAll(c.path("al"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if got := reply(t, c, ft, homeMessage("clyde, quote someone")); got != nobody {
+		t.Errorf("with an empty act-like directory: got %q", got)
+	}
+
+	writeActLike(t, c, "ben bitdiddle", "I broke it", "it works on my machine")
+	writeActLike(t, c, "a/b", "slashes!")
+	// Files with no phrases or undecodable names are skipped
+	if err := os.WriteFile(c.path(actLikeFile("alyssa")), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(c.path(path.Join("al", "bad\\q")), []byte("x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	valid := map[string]bool{
+		"ben bitdiddle once said: I broke it": true,
+		"ben bitdiddle once said: it works on my machine": true,
+		"a/b once said: slashes!": true,
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < 30; i++ {
+		got := reply(t, c, ft, homeMessage("clyde, quote somebody!"))
+		if !valid[got] {
+			t.Fatalf("got %q", got)
+		}
+		seen[got] = true
+	}
+	if len(seen) != len(valid) {
+		t.Errorf("only quoted %v", seen)
+	}
+}
+
+func TestTalkSpeed(t *testing.T) {
+	c, ft, _ := newTestClyde(t, `{"SendDelayFactor": 20}`)
+	for _, want := range []int{10, 5, minSendDelayFactor} {
+		if got := reply(t, c, ft, homeMessage("clyde, talk faster")); !strings.HasPrefix(got, "Ok, now I'm typing at") {
+			t.Errorf("got %q", got)
+		}
+		if c.config.SendDelayFactor != want {
+			t.Errorf("SendDelayFactor is %d, want %d", c.config.SendDelayFactor, want)
+		}
+	}
+	if got := reply(t, c, ft, homeMessage("clyde, talk faster")); got != "I can't type any faster than this!" {
+		t.Errorf("got %q", got)
+	}
+	if c.config.SendDelayFactor != minSendDelayFactor {
+		t.Errorf("SendDelayFactor is %d below the minimum", c.config.SendDelayFactor)
+	}
+
+	for c.config.SendDelayFactor < maxSendDelayFactor {
+		before := c.config.SendDelayFactor
+		reply(t, c, ft, homeMessage("clyde, type slower"))
+		if c.config.SendDelayFactor <= before {
+			t.Fatalf("SendDelayFactor went from %d to %d talking slower", before, c.config.SendDelayFactor)
+		}
+	}
+	if got := reply(t, c, ft, homeMessage("clyde, talk slower")); got != "I can't type any slower than this!" {
+		t.Errorf("got %q", got)
+	}
+	if c.config.SendDelayFactor != maxSendDelayFactor {
+		t.Errorf("SendDelayFactor is %d, want the maximum", c.config.SendDelayFactor)
+	}
+
+	config, err := c.loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.SendDelayFactor != maxSendDelayFactor {
+		t.Errorf("saved SendDelayFactor %d", config.SendDelayFactor)
+	}
+
+	sketchy := homeMessage("clyde, talk faster")
+	sketchy.AuthStatus = zephyr.AuthNo
+	reply(t, c, ft, sketchy)
+	if c.config.SendDelayFactor != maxSendDelayFactor {
+		t.Error("changed speed for an unauthenticated sender")
+	}
+}
+
+func TestStatus(t *testing.T) {
+	c, ft, clock := newTestClyde(t, "")
+	c.subs["fun"] = subscription{Policy: FULL, Instance: "*"}
+	c.subs["games"] = subscription{Policy: LISTEN, Instance: "*"}
+	clock.Advance(26*time.Hour + 3*time.Minute)
+	got := reply(t, c, ft, homeMessage("clyde, how long have you been running?"))
+	want := "I've been running for 1 day, 2 hours, and 3 minutes (version dev). I'm ok, and I'm subbed to 2 classes besides my homes."
+	if got = unwrap(got); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestStolenCat(t *testing.T) {
+	c, ft, clock := newTestClyde(t, "")
+	if got := reply(t, c, ft, homeMessage("clyde, did you steal zeroday?")); got != "Who, me? Never." {
+		t.Errorf("not stolen: got %q", got)
+	}
+	if got := reply(t, c, ft, homeMessage("clyde, give zeroday back")); got != "I don't have zeroday!" {
+		t.Errorf("give back when not stolen: got %q", got)
+	}
+	if stoleCat(c, homeMessage("clyde, did you steal my lunch?")) {
+		t.Error("stoleCat claimed a question about something else")
+	}
+
+	c.cat.Stolen = true
+	c.cat.StolenTime = clock.Now()
+	c.cat.StolenClass, c.cat.StolenInstance = "cats", "lounge"
+	c.cat.Class, c.cat.Instance = homeClass, homeInstance
+	clock.Advance(10 * time.Minute)
+	if got := unwrap(reply(t, c, ft, homeMessage("clyde, did you take the cat?"))); got != "zeroday has just been visiting for 10 minutes! I'll bring her back to -c cats soon." {
+		t.Errorf("stolen: got %q", got)
+	}
+
+	sketchy := homeMessage("clyde, give back zeroday")
+	sketchy.AuthStatus = zephyr.AuthNo
+	if got := reply(t, c, ft, sketchy); got != "You look sketchy, I don't trust you..." {
+		t.Errorf("got %q", got)
+	}
+
+	c.handleMessage(homeMessage("clyde, give back zeroday!"))
+	sent := ft.sends()
+	if len(sent) != 2 || sent[0].body != "zeroday::scoop" || sent[1].body != "Ok, I'll take zeroday back to -c cats." {
+		t.Errorf("giving back the cat sent %v", sent)
+	}
+	if c.cat.State != cat.TryScoop {
+		t.Errorf("cat is %v after giving her back", c.cat.State)
+	}
+}
+
+func TestWhy(t *testing.T) {
+	c, ft, _ := newTestClyde(t, `{"Personals": true, "Principal": "clyde@ATHENA.MIT.EDU"}`)
+	why := homeMessage("clyde, why did you say that?")
+	if got := reply(t, c, ft, why); got != "I haven't done anything yet." {
+		t.Errorf("before doing anything, got %q", got)
+	}
+
+	reply(t, c, ft, homeMessage("clyde, roll 1d1"))
+	const want = "That was my dice behavior, matching "
+	if got := unwrap(reply(t, c, ft, why)); !strings.HasPrefix(got, want) {
+		t.Errorf("after rolling, got %q", got)
+	}
+	// Neither the explanation itself nor a personal replace the
+	// last behavior
+	reply(t, c, ft, personal("alice", "clyde, what is 1+1?"))
+	if got := unwrap(reply(t, c, ft, why)); !strings.HasPrefix(got, want) {
+		t.Errorf("after a personal, got %q", got)
+	}
+}
+
+func TestSetMood(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	if got := reply(t, c, ft, homeMessage("clyde, be lonely")); got != "Ok, now I'm lonely :(" {
+		t.Errorf("be lonely: got %q", got)
+	}
+	if c.mood != mood.Lonely {
+		t.Errorf("mood is %v, want lonely", c.mood)
+	}
+	if got := reply(t, c, ft, homeMessage("clyde, set mood to a turnip!")); got != "Ok, now I'm a turnip." {
+		t.Errorf("set mood to a turnip: got %q", got)
+	}
+
+	r := homeMessage("clyde, be great")
+	r.AuthStatus = zephyr.AuthNo
+	if got := reply(t, c, ft, r); got != "You look sketchy, I don't trust you..." {
+		t.Errorf("unauthenticated: got %q", got)
+	}
+	if c.mood != mood.Turnip {
+		t.Errorf("unauthenticated sender changed mood to %v", c.mood)
+	}
+
+	got := reply(t, c, ft, homeMessage("clyde, set mood sleepy"))
+	if !strings.HasPrefix(got, "I don't know how to be sleepy.") || !strings.Contains(got, "great") {
+		t.Errorf("invalid mood: got %q", got)
+	}
+	if setMood(c, homeMessage("clyde, be quiet")) {
+		t.Error("setMood claimed \"be quiet\"")
+	}
+}
+
+func TestCoin(t *testing.T) {
+	flip := func() string {
+		c, ft, _ := newTestClyde(t, "")
+		return reply(t, c, ft, homeMessage("clyde, flip a coin"))
+	}
+	first := flip()
+	if first != "Heads" && first != "Tails" {
+		t.Errorf("flipped %q", first)
+	}
+	for i := 0; i < 5; i++ {
+		if got := flip(); got != first {
+			t.Errorf("same seed flipped %q, then %q", first, got)
+		}
+	}
+
+	c, ft, _ := newTestClyde(t, "")
+	got := reply(t, c, ft, homeMessage("clyde, flip 3 coins"))
+	flips := strings.Split(strings.ToLower(got), ", ")
+	if len(flips) != 3 {
+		t.Errorf("flipping 3 coins: got %q", got)
+	}
+	for _, f := range flips {
+		if f != "heads" && f != "tails" {
+			t.Errorf("flipping 3 coins: got %q", got)
+		}
+	}
+
+	tests := []struct {
+		body, want string
+	}{
+		{"clyde, flip 0 coins", "Ok, I flipped no coins."},
+		{"clyde, flip 21 coins", "I don't have that many coins!"},
+		{"clyde, toss 99999999999999999999 coins", "I don't have that many coins!"},
+	}
+	for _, test := range tests {
+		if got := reply(t, c, ft, homeMessage(test.body)); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.body, got, test.want)
+		}
+	}
+
+	// Coin flips aren't mistaken for a choice
+	if !coin(c, homeMessage("clyde, heads or tails?")) {
+		t.Error("heads or tails didn't trigger coin")
+	}
+	if behaviorIndex(t, "coin") > behaviorIndex(t, "choose") {
+		t.Error("choose is tried before coin")
+	}
+}
+
+func TestReverseBehavior(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	tests := []struct {
+		body, want string
+	}{
+		{"clyde, reverse stressed 🐱", "🐱 desserts"},
+		{"clyde, reverse:", "Reverse what?"},
+		{"clyde, reverse", "Reverse what?"},
+	}
+	for _, test := range tests {
+		if got := reply(t, c, ft, homeMessage(test.body)); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.body, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2016 Sam Dukhovni <dukhovni@mit.edu>
+//
+// Licensed under the MIT License
+// (https://opensource.org/licenses/MIT)
+//
+//
+// calc.go defines a small evaluator for integer arithmetic
+// expressions, so Clyde can answer simple math questions.
+
+package clyde
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"unicode"
+)
+
+var errDivideByZero = errors.New("division by zero")
+var errOverflow = errors.New("integer overflow")
+
+// evalArithmetic evaluates an expression of integers combined with +,
+// -, *, / (integer division), and parentheses, following the usual
+// precedence rules.
+func evalArithmetic(expr string) (int64, error) {
+	p := &arithParser{input: []rune(expr)}
+	value, err := p.parseSum()
+	if err != nil {
+		return 0, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return 0, fmt.Errorf("unexpected %q", p.input[p.pos])
+	}
+	return value, nil
+}
+
+// arithParser is a recursive-descent parser for arithmetic
+// expressions, evaluating them as it goes.
+type arithParser struct {
+	input []rune
+	pos int
+}
+
+func (p *arithParser) skipSpace() {
+	for p.pos < len(p.input) && unicode.IsSpace(p.input[p.pos]) {
+		p.pos++
+	}
+}
+
+// next returns the next non-space rune without consuming it, or 0 at
+// the end of the input.
+func (p *arithParser) next() rune {
+	p.skipSpace()
+	if p.pos >= len(p.input) {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+// parseSum parses terms separated by + and -.
+func (p *arithParser) parseSum() (int64, error) {
+	value, err := p.parseProduct()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := p.next()
+		if op != '+' && op != '-' {
+			return value, nil
+		}
+		p.pos++
+		rhs, err := p.parseProduct()
+		if err != nil {
+			return 0, err
+		}
+		if op == '-' {
+			if rhs == math.MinInt64 {
+				return 0, errOverflow
+			}
+			rhs = -rhs
+		}
+		if (rhs > 0 && value > math.MaxInt64-rhs) || (rhs < 0 && value < math.MinInt64-rhs) {
+			return 0, errOverflow
+		}
+		value += rhs
+	}
+}
+
+// parseProduct parses factors separated by * and /.
+func (p *arithParser) parseProduct() (int64, error) {
+	value, err := p.parseFactor()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := p.next()
+		if op != '*' && op != '/' {
+			return value, nil
+		}
+		p.pos++
+		rhs, err := p.parseFactor()
+		if err != nil {
+			return 0, err
+		}
+		if op == '/' {
+			if rhs == 0 {
+				return 0, errDivideByZero
+			}
+			if value == math.MinInt64 && rhs == -1 {
+				return 0, errOverflow
+			}
+			value /= rhs
+			continue
+		}
+		product := value * rhs
+		if value != 0 && (product/value != rhs || (value == -1 && rhs == math.MinInt64)) {
+			return 0, errOverflow
+		}
+		value = product
+	}
+}
+
+// parseFactor parses a number, a negated factor, or a parenthesized
+// expression.
+func (p *arithParser) parseFactor() (int64, error) {
+	switch ch := p.next(); {
+	case ch == '-':
+		p.pos++
+		value, err := p.parseFactor()
+		if err != nil {
+			return 0, err
+		}
+		if value == math.MinInt64 {
+			return 0, errOverflow
+		}
+		return -value, nil
+	case ch == '(':
+		p.pos++
+		value, err := p.parseSum()
+		if err != nil {
+			return 0, err
+		}
+		if p.next() != ')' {
+			return 0, errors.New("missing )")
+		}
+		p.pos++
+		return value, nil
+	case unicode.IsDigit(ch):
+		start := p.pos
+		for p.pos < len(p.input) && unicode.IsDigit(p.input[p.pos]) {
+			p.pos++
+		}
+		value, err := strconv.ParseInt(string(p.input[start:p.pos]), 10, 64)
+		if err != nil {
+			return 0, errOverflow
+		}
+		return value, nil
+	case ch == 0:
+		return 0, errors.New("unexpected end of expression")
+	default:
+		return 0, fmt.Errorf("unexpected %q", ch)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2016 Sam Dukhovni <dukhovni@mit.edu>
+//
+// Licensed under the MIT License
+// (https://opensource.org/licenses/MIT)
+
+package clyde
+
+import (
+	"testing"
+)
+
+func TestEvalArithmetic(t *testing.T) {
+	tests := []struct {
+		expr string
+		want int64
+		err error
+	}{
+		{"12 * 7", 84, nil},
+		{"1 + 2 * 3", 7, nil},
+		{"(1 + 2) * 3", 9, nil},
+		{"10 - 4 - 3", 3, nil},
+		{"7 / 2", 3, nil},
+		{"-(2 + 3) * -2", 10, nil},
+		{"((42))", 42, nil},
+		{"1 / 0", 0, errDivideByZero},
+		{"1 / (2 - 2)", 0, errDivideByZero},
+		{"9223372036854775807 + 1", 0, errOverflow},
+		{"99999999999999999999", 0, errOverflow},
+		{"4611686018427387904 * 2", 0, errOverflow},
+	}
+	for _, test := range tests {
+		got, err := evalArithmetic(test.expr)
+		if got != test.want || err != test.err {
+			t.Errorf("evalArithmetic(%q) = %d, %v, want %d, %v", test.expr, got, err, test.want, test.err)
+		}
+	}
+
+	for _, bad := range []string{"", "1 +", "(1 + 2", "1 2", "2 ** 3"} {
+		if _, err := evalArithmetic(bad); err == nil {
+			t.Errorf("evalArithmetic(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestCalc(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	tests := []struct {
+		body, want string
+	}{
+		{"clyde, what is 12 * 7?", "12 * 7 is 84."},
+		{"clyde, what's (1 + 2) * 3", "(1 + 2) * 3 is 9."},
+		{"clyde, what is 1 / 0?", "You can't divide by zero, silly!"},
+		{"clyde, what is 99999999999999999999?", "That number's too big for me to count!"},
+		{"clyde, what is 1 + + 2?", "That doesn't look like math to me..."},
+	}
+	for _, test := range tests {
+		if got := reply(t, c, ft, homeMessage(test.body)); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.body, got, test.want)
+		}
+	}
+
+	if calc(c, homeMessage("clyde, what is love?")) {
+		t.Error("calc claimed a question that isn't arithmetic")
+	}
+}
+
+func TestCalcBeforeChat(t *testing.T) {
+	if behaviorIndex(t, "calc") > behaviorIndex(t, "chat") {
+		t.Error("chat comes before calc")
+	}
+}
@@ -12,12 +12,14 @@ package cat
 
 import (
 	"fmt"
+	"math/rand"
 	"regexp"
 	"time"
 )
 
 // Cat is a structure for keeping track of the cat.
 type Cat struct {
+	Name string
 	Class string
 	Instance string
 	State CatState
@@ -25,6 +27,12 @@ type Cat struct {
 	StolenTime time.Time
 	StolenClass string
 	StolenInstance string
+	// LastPlayCmd is the last command used to try to play with
+	// the cat.
+	LastPlayCmd string
+	// PlaySuccesses counts how many times each of PlayCmds has
+	// gotten a reaction from the cat.
+	PlaySuccesses map[string]int
 }
 
 // CatState represents different states the cat can be in, with
@@ -41,6 +49,39 @@ const (
 	Traveling	CatState = 6
 )
 
+var catStateNames = []string{"Normal", "TryScoop", "WeScooped", "WeCarrying", "TryDeposit", "TryPlay", "Traveling"}
+
+// String returns the name of a state, for logging.
+func (s CatState) String() string {
+	if s < 0 || int(s) >= len(catStateNames) {
+		return fmt.Sprintf("Unknown(%d)", int(s))
+	}
+	return catStateNames[s]
+}
+
+// Description describes a state in words, for finishing the sentence
+// "The cat is _____".
+func (s CatState) Description() string {
+	switch s {
+	case Normal:
+		return "hanging out"
+	case TryScoop:
+		return "about to get scooped"
+	case WeScooped:
+		return "in Clyde's arms"
+	case WeCarrying:
+		return "being carried by Clyde"
+	case TryDeposit:
+		return "being set down by Clyde"
+	case TryPlay:
+		return "playing with Clyde"
+	case Traveling:
+		return "traveling"
+	default:
+		return "somewhere mysterious"
+	}
+}
+
 // CatAction represents different actions the cat can perform.
 type CatAction int
 
@@ -52,9 +93,22 @@ const (
 	Enter		CatAction = 4
 	Deposited	CatAction = 5
 	Bored		CatAction = 6
+	NoAction	CatAction = 7 // a message that isn't any recognized action
 )
 
+var catActionNames = []string{"React", "Scooped", "ScoopFailed", "Leave", "Enter", "Deposited", "Bored", "NoAction"}
+
+// String returns the name of an action, for logging.
+func (a CatAction) String() string {
+	if a < 0 || int(a) >= len(catActionNames) {
+		return fmt.Sprintf("Unknown(%d)", int(a))
+	}
+	return catActionNames[a]
+}
+
 
+// CatName is the name of the cat Clyde looks for if none is
+// configured.
 const CatName = "zeroday"
 const StealDuration = 30*time.Minute
 
@@ -71,7 +125,8 @@ var ActionPatterns = map[CatAction]string {
 // ParseAction parses a message from the cat to determine what action
 // is being performed, and possibly what user it's being performed
 // with (if the user cannot be determined, the second return value is
-// empty).
+// empty). If the message isn't a recognized action, it returns
+// NoAction.
 func ParseAction(msg string) (CatAction, string) {
 	for action,pattern := range ActionPatterns {
 		rex := regexp.MustCompile(pattern)
@@ -83,11 +138,13 @@ func ParseAction(msg string) (CatAction, string) {
 		return action, user
 	}
 
-	return Bored, ""
+	return NoAction, ""
 }
 
-func CatCmd(cmd string) string {
-	return fmt.Sprintf("%s::%s", CatName, cmd)
+// CatCmd returns the zephyr that tells the named cat to perform a
+// command.
+func CatCmd(name, cmd string) string {
+	return fmt.Sprintf("%s::%s", name, cmd)
 }
 
 var PlayCmds = []string {
@@ -99,3 +156,33 @@ var PlayCmds = []string {
 	"hug",
 	"boop",
 }
+
+// ChoosePlayCmd randomly chooses one of PlayCmds, weighting each by
+// one more than the number of times it has succeeded, so commands the
+// cat likes are preferred but every command still gets tried.
+func (c *Cat) ChoosePlayCmd(rng *rand.Rand) string {
+	total := 0
+	for _, cmd := range PlayCmds {
+		total += c.PlaySuccesses[cmd] + 1
+	}
+	n := rng.Intn(total)
+	for _, cmd := range PlayCmds {
+		n -= c.PlaySuccesses[cmd] + 1
+		if n < 0 {
+			return cmd
+		}
+	}
+	return PlayCmds[len(PlayCmds)-1]
+}
+
+// PlaySucceeded records that the last play command got a reaction
+// from the cat.
+func (c *Cat) PlaySucceeded() {
+	if c.LastPlayCmd == "" {
+		return
+	}
+	if c.PlaySuccesses == nil {
+		c.PlaySuccesses = make(map[string]int)
+	}
+	c.PlaySuccesses[c.LastPlayCmd]++
+}
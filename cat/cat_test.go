@@ -0,0 +1,140 @@
+// Copyright 2016 Sam Dukhovni <dukhovni@mit.edu>
+//
+// Licensed under the MIT License
+// (https://opensource.org/licenses/MIT)
+
+package cat
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestChoosePlayCmd(t *testing.T) {
+	c := Cat{PlaySuccesses: map[string]int{"treat": 93}}
+	rng := rand.New(rand.NewSource(1))
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		counts[c.ChoosePlayCmd(rng)]++
+	}
+	// treat has weight 94 out of 100
+	if counts["treat"] < 900 {
+		t.Errorf("chose treat %d times out of 1000, want about 940", counts["treat"])
+	}
+	if counts["treat"] == 1000 {
+		t.Error("never chose anything but treat")
+	}
+}
+
+func TestChoosePlayCmdUnweighted(t *testing.T) {
+	var c Cat
+	rng := rand.New(rand.NewSource(1))
+	counts := make(map[string]int)
+	for i := 0; i < 700; i++ {
+		counts[c.ChoosePlayCmd(rng)]++
+	}
+	for _, cmd := range PlayCmds {
+		if counts[cmd] < 50 {
+			t.Errorf("chose %s %d times out of 700, want about 100", cmd, counts[cmd])
+		}
+	}
+}
+
+func TestPlaySucceeded(t *testing.T) {
+	var c Cat
+	c.PlaySucceeded()
+	if len(c.PlaySuccesses) != 0 {
+		t.Errorf("counted a success with no play command: %v", c.PlaySuccesses)
+	}
+	c.LastPlayCmd = "boop"
+	c.PlaySucceeded()
+	c.PlaySucceeded()
+	if c.PlaySuccesses["boop"] != 2 {
+		t.Errorf("PlaySuccesses is %v, want 2 boops", c.PlaySuccesses)
+	}
+}
+
+func TestParseAction(t *testing.T) {
+	tests := []struct {
+		msg string
+		action CatAction
+		user string
+	}{
+		{"zeroday purrs", React, ""},
+		{"zeroday bats at clyde", React, "clyde"},
+		{"clyde scoops up zeroday", Scooped, "clyde"},
+		{"zeroday curls up", Bored, ""},
+		{"zeroday is a very good cat", NoAction, ""},
+		{"", NoAction, ""},
+	}
+	for _, test := range tests {
+		action, user := ParseAction(test.msg)
+		if action != test.action || user != test.user {
+			t.Errorf("ParseAction(%q) = %v, %q, want %v, %q", test.msg, action, user, test.action, test.user)
+		}
+	}
+}
+
+func TestCatCmd(t *testing.T) {
+	if got := CatCmd("mittens", "scoop"); got != "mittens::scoop" {
+		t.Errorf("CatCmd = %q", got)
+	}
+}
+
+func TestCatStateString(t *testing.T) {
+	tests := []struct {
+		s CatState
+		name, description string
+	}{
+		{Normal, "Normal", "hanging out"},
+		{TryScoop, "TryScoop", "about to get scooped"},
+		{WeScooped, "WeScooped", "in Clyde's arms"},
+		{WeCarrying, "WeCarrying", "being carried by Clyde"},
+		{TryDeposit, "TryDeposit", "being set down by Clyde"},
+		{TryPlay, "TryPlay", "playing with Clyde"},
+		{Traveling, "Traveling", "traveling"},
+	}
+	for _, test := range tests {
+		if got := test.s.String(); got != test.name {
+			t.Errorf("CatState(%d).String() = %q, want %q", int(test.s), got, test.name)
+		}
+		if got := test.s.Description(); got != test.description {
+			t.Errorf("%v.Description() = %q, want %q", test.s, got, test.description)
+		}
+	}
+}
+
+func TestCatActionString(t *testing.T) {
+	tests := []struct {
+		a CatAction
+		want string
+	}{
+		{React, "React"},
+		{Scooped, "Scooped"},
+		{ScoopFailed, "ScoopFailed"},
+		{Leave, "Leave"},
+		{Enter, "Enter"},
+		{Deposited, "Deposited"},
+		{Bored, "Bored"},
+		{NoAction, "NoAction"},
+		{CatAction(-1), "Unknown(-1)"},
+		{CatAction(42), "Unknown(42)"},
+	}
+	for _, test := range tests {
+		if got := test.a.String(); got != test.want {
+			t.Errorf("CatAction(%d).String() = %q, want %q", int(test.a), got, test.want)
+		}
+	}
+}
+
+func TestUnknownCatState(t *testing.T) {
+	for _, s := range []CatState{-1, 7, 42} {
+		if got, want := s.String(), fmt.Sprintf("Unknown(%d)", int(s)); got != want {
+			t.Errorf("CatState(%d).String() = %q, want %q", int(s), got, want)
+		}
+		if got := s.Description(); got != "somewhere mysterious" {
+			t.Errorf("CatState(%d).Description() = %q", int(s), got)
+		}
+	}
+}
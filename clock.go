@@ -0,0 +1,93 @@
+// Copyright 2016 Sam Dukhovni <dukhovni@mit.edu>
+//
+// Licensed under the MIT License
+// (https://opensource.org/licenses/MIT)
+//
+//
+// clock.go defines the clock Clyde uses to tell time, so that his
+// time-dependent behavior can be driven by a fake clock.
+
+package clyde
+
+import (
+	"time"
+)
+
+// A Clock tells Clyde what time it is, and lets him wait.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// A Ticker delivers ticks of a Clock on a channel at regular
+// intervals, like a time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// realClock is a Clock that uses real time.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+// realTicker is a Ticker that ticks in real time.
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// SetClock replaces the clock Clyde uses, e.g. with a fake clock for
+// testing. It should be called before Run. Times Clyde has already
+// recorded are shifted onto the new clock, so that e.g. his uptime
+// and how long he's been alone carry over.
+func (c *Clyde) SetClock(clock Clock) {
+	offset := clock.Now().Sub(c.clock.Now())
+	rebase := func(t *time.Time) {
+		if !t.IsZero() {
+			*t = t.Add(offset)
+		}
+	}
+	rebase(&c.startTime)
+	rebase(&c.lastInteraction)
+	rebase(&c.lastSaved)
+	rebase(&c.lastZsigRotation)
+	rebase(&c.cat.StolenTime)
+	for key, t := range c.cooldowns {
+		rebase(&t)
+		c.cooldowns[key] = t
+	}
+	for i := range c.exchanges.entries {
+		rebase(&c.exchanges.entries[i].time)
+	}
+
+	c.clock = clock
+	c.ticker.Stop()
+	c.ticker = clock.NewTicker(tickInterval)
+	setNow(c.chain, clock.Now)
+	setNow(c.zsigChain, clock.Now)
+}
+
+// since returns the time elapsed since t according to Clyde's clock.
+func (c *Clyde) since(t time.Time) time.Duration {
+	return c.clock.Now().Sub(t)
+}
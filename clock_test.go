@@ -0,0 +1,207 @@
+// Copyright 2016 Sam Dukhovni <dukhovni@mit.edu>
+//
+// Licensed under the MIT License
+// (https://opensource.org/licenses/MIT)
+
+package clyde
+
+import (
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"github.com/sdukhovni/clyde-go/cat"
+	"github.com/sdukhovni/clyde-go/mood"
+)
+
+func TestLonelinessOnset(t *testing.T) {
+	c, _, clock := newTestClyde(t, `{"LonelyAfter": "1h", "LonelyInterval": "1m", "LonelyJitter": "0s", "ChatterAfter": "100h"}`)
+	c.lastInteraction = clock.Now()
+
+	clock.Advance(time.Hour - time.Second)
+	c.handleTick(clock.Now())
+	if c.mood != mood.Ok {
+		t.Errorf("mood is %v just before Clyde can get lonely", c.mood)
+	}
+	clock.Advance(time.Second)
+	c.handleTick(clock.Now())
+	if c.mood != mood.Lonely {
+		t.Errorf("mood is %v once Clyde can get lonely", c.mood)
+	}
+}
+
+func TestStolenCatReturned(t *testing.T) {
+	c, ft, clock := newTestClyde(t, `{"ChatterAfter": "100h", "LonelyAfter": "100h"}`)
+	c.lastInteraction = clock.Now()
+	c.cat.Stolen = true
+	c.cat.StolenTime = clock.Now()
+	c.cat.Class, c.cat.Instance = homeClass, homeInstance
+
+	clock.Advance(cat.StealDuration)
+	c.handleTick(clock.Now())
+	if sent := ft.sends(); len(sent) != 0 {
+		t.Errorf("sent %v before the cat's visit was over", sent)
+	}
+	clock.Advance(time.Second)
+	c.handleTick(clock.Now())
+	sent := ft.sends()
+	if len(sent) != 1 || sent[0].body != "zeroday::scoop" || sent[0].class != homeClass {
+		t.Errorf("sent %v once the cat's visit was over", sent)
+	}
+}
+
+func TestZsigRotation(t *testing.T) {
+	c, ft, clock := newTestClyde(t, `{"ZsigRotateInterval": "1h", "ChatterAfter": "100h", "LonelyAfter": "100h"}`)
+	c.lastInteraction = clock.Now()
+	c.zsigChain.Build(strings.NewReader("purple monkey dishwasher. colorless green ideas sleep furiously. the quick brown fox."))
+	c.currentZsig = "Clyde the Great"
+	c.sendHome("hello")
+	if sent := ft.sends(); len(sent) != 1 || sent[0].zsig != "Clyde the Great" {
+		t.Errorf("sent %v", sent)
+	}
+
+	c.lastZsigRotation = clock.Now()
+	clock.Advance(time.Hour - time.Second)
+	c.handleTick(clock.Now())
+	if c.currentZsig != "Clyde the Great" {
+		t.Errorf("rotated zsig to %q too early", c.currentZsig)
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < 5; i++ {
+		clock.Advance(time.Hour)
+		c.handleTick(clock.Now())
+		seen[c.currentZsig] = true
+	}
+	if seen["Clyde the Great"] || len(seen) < 2 {
+		t.Errorf("rotated through zsigs %v", seen)
+	}
+}
+
+func TestZsigRotationDisabled(t *testing.T) {
+	c, _, clock := newTestClyde(t, `{"ChatterAfter": "100h", "LonelyAfter": "100h"}`)
+	c.lastInteraction = clock.Now()
+	c.zsigChain.Build(strings.NewReader("purple monkey dishwasher."))
+	c.currentZsig = "Clyde the Great"
+	clock.Advance(24 * time.Hour)
+	c.lastInteraction = clock.Now()
+	c.handleTick(clock.Now())
+	if c.currentZsig != "Clyde the Great" {
+		t.Errorf("rotated zsig to %q", c.currentZsig)
+	}
+}
+
+func TestLonelyJitter(t *testing.T) {
+	const config = `{"LonelyAfter": "1h", "LonelyInterval": "1m", "LonelyJitter": "30m", "ChatterAfter": "100h"}`
+	c, _, clock := newTestClyde(t, config)
+	thresholds := make(map[time.Duration]bool)
+	for seed := int64(1); seed <= 20; seed++ {
+		c.SetRand(rand.New(rand.NewSource(seed)))
+		if c.lonelyAfter < time.Hour || c.lonelyAfter > 90*time.Minute {
+			t.Fatalf("seed %d: lonely after %v", seed, c.lonelyAfter)
+		}
+		thresholds[c.lonelyAfter] = true
+	}
+	if len(thresholds) < 10 {
+		t.Errorf("only %d different thresholds from 20 seeds", len(thresholds))
+	}
+
+	c.SetRand(rand.New(rand.NewSource(1)))
+	if c.lonelyAfter == time.Hour {
+		t.Fatal("seed 1 didn't add any jitter")
+	}
+	c.lastInteraction = clock.Now()
+	clock.Advance(c.lonelyAfter - time.Second)
+	c.handleTick(clock.Now())
+	if c.mood != mood.Ok {
+		t.Errorf("mood is %v just before the jittered onset", c.mood)
+	}
+	clock.Advance(time.Second)
+	c.handleTick(clock.Now())
+	if c.mood != mood.Lonely {
+		t.Errorf("mood is %v at the jittered onset", c.mood)
+	}
+}
+
+// savedFiles returns which of Clyde's autosaved files exist in his
+// home directory.
+func savedFiles(c *Clyde) map[string]bool {
+	saved := make(map[string]bool)
+	for _, f := range []string{chainFile + compressedSuffix, zsigChainFile + compressedSuffix, subsFile, stateFile} {
+		if _, err := os.Stat(c.path(f)); err == nil {
+			saved[f] = true
+		}
+	}
+	return saved
+}
+
+func TestAutosave(t *testing.T) {
+	c, _, clock := newTestClyde(t, `{"AutosaveInterval": "1m", "ChatterAfter": "100h", "LonelyAfter": "100h"}`)
+	c.lastInteraction = clock.Now()
+	c.lastSaved = clock.Now()
+	c.learn(homeMessage("the cat sat on the mat."))
+
+	clock.Advance(time.Minute - time.Second)
+	c.handleTick(clock.Now())
+	if saved := savedFiles(c); len(saved) != 0 {
+		t.Errorf("saved %v before the autosave interval", saved)
+	}
+	clock.Advance(time.Second)
+	c.handleTick(clock.Now())
+	if saved := savedFiles(c); len(saved) != 4 {
+		t.Errorf("autosave only saved %v", saved)
+	}
+	loaded, _, _ := loadTestClyde(t, c.homeDir)
+	if chainSize(loaded) != chainSize(c) {
+		t.Errorf("autosaved chain has %d prefixes, want %d", chainSize(loaded), chainSize(c))
+	}
+}
+
+func TestAutosaveError(t *testing.T) {
+	c, _, clock := newTestClyde(t, `{"AutosaveInterval": "1m", "ChatterAfter": "100h", "LonelyAfter": "100h"}`)
+	c.lastInteraction = clock.Now()
+	c.lastSaved = clock.Now()
+	// A directory in the way of the chain file makes saving it fail
+	if err := os.Mkdir(c.path(chainFile+compressedSuffix), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(time.Minute)
+	c.handleTick(clock.Now())
+	if saved := savedFiles(c); !saved[subsFile] || !saved[stateFile] {
+		t.Errorf("after failing to save the chain, only saved %v", saved)
+	}
+	if !c.lastSaved.Equal(clock.Now()) {
+		t.Error("didn't wait for the next interval after a failed save")
+	}
+}
+
+func TestAutosaveDisabled(t *testing.T) {
+	c, _, clock := newTestClyde(t, `{"AutosaveInterval": "0s", "ChatterAfter": "100h", "LonelyAfter": "100h"}`)
+	c.lastInteraction = clock.Now()
+	clock.Advance(24 * time.Hour)
+	c.lastInteraction = clock.Now()
+	c.handleTick(clock.Now())
+	if saved := savedFiles(c); len(saved) != 0 {
+		t.Errorf("saved %v with autosave disabled", saved)
+	}
+}
+
+func TestSetClockRebases(t *testing.T) {
+	c, _, clock := newTestClyde(t, "")
+	c.lastInteraction = clock.Now().Add(-time.Hour)
+	c.cooldowns["story alice"] = clock.Now().Add(-time.Minute)
+
+	later := newFakeClock()
+	later.Advance(1000 * time.Hour)
+	c.SetClock(later)
+	if alone := c.since(c.lastInteraction); alone != time.Hour {
+		t.Errorf("alone for %v after changing clocks, want 1h", alone)
+	}
+	if cooldown := c.since(c.cooldowns["story alice"]); cooldown != time.Minute {
+		t.Errorf("cooldown started %v ago after changing clocks, want 1m", cooldown)
+	}
+	if !c.cat.StolenTime.IsZero() {
+		t.Error("changing clocks set a time that was never recorded")
+	}
+}
@@ -18,46 +18,95 @@ package clyde
 
 import (
 	"strings"
-	"log"
 	"time"
 	"math/rand"
 	"path"
 	"os"
+	"io"
 	"encoding/json"
 	"sync"
 	"fmt"
-	"github.com/zephyr-im/krb5-go"
+	"bufio"
+	"errors"
+	"runtime/debug"
 	"github.com/zephyr-im/zephyr-go"
 	"github.com/sdukhovni/clyde-go/markov"
 	"github.com/sdukhovni/clyde-go/mood"
 	"github.com/sdukhovni/clyde-go/cat"
 	"github.com/sdukhovni/clyde-go/stringutil"
 	"github.com/sdukhovni/clyde-go/util"
+	"github.com/sdukhovni/clyde-go/logger"
 )
 
 // Clyde (the struct) holds all of the internal state needed for Clyde
 // (the zephyrbot) to send and receive zephyrs, generate text, and
 // load/save persistent state data.
 type Clyde struct {
-	chain *markov.Chain
-	zsigChain *markov.Chain
+	chain Generator
+	zsigChain Generator
 	homeDir string
-	session *zephyr.Session
-	ctx *krb5.Context
-	subs map[string]classPolicy
+	config Config
+	log *logger.Logger
+	transport Transport
+	subs map[string]subscription
 	mood mood.Mood
 	lastInteraction time.Time
 	lastSaved time.Time
-	ticker *time.Ticker
+	ticker Ticker
 	cat cat.Cat
 	shutdown chan struct{}
 	wg sync.WaitGroup
+	counters counters
+	rng *rand.Rand
+	running bool
+	done chan struct{}
+	reloads chan chan error
+	cooldowns map[string]time.Time
+	startTime time.Time
+	exchanges exchangeLog
+	lastSent string // body of the last message sent, for loop detection
+	clock Clock
+	published published
+	lastTriggered triggered
+	matchedPattern string // pattern matched by the running behavior, if any
+	currentZsig string // overrides the default zsig until restart
+	lastZsigRotation time.Time
+	lonelyAfter time.Duration // LonelyAfter plus this instance's jitter
 }
 
+// triggered records a behavior that triggered, and the pattern it
+// matched, if any. It doesn't record the message itself, which might
+// not be fit to repeat.
+type triggered struct {
+	name string
+	pattern string
+}
+
+// Version is the version of Clyde that's running, normally set at
+// build time with
+// -ldflags "-X github.com/sdukhovni/clyde-go.Version=<version>".
+var Version = "dev"
+
 // LoadClyde initializes a Clyde by loading data files found in the
 // given directory, returning an error if the directory does not
 // exist and cannot be created.
 func LoadClyde(dir string) (*Clyde, error) {
+	t, err := dialZephyr()
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewClydeWithTransport(dir, t)
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewClydeWithTransport initializes a Clyde like LoadClyde, but sends
+// and receives zephyrs using the given Transport instead of dialing
+// zephyr.
+func NewClydeWithTransport(dir string, t Transport) (*Clyde, error) {
 	err := os.MkdirAll(dir, 0755)
 	if err != nil {
 		return nil, err
@@ -66,35 +115,55 @@ func LoadClyde(dir string) (*Clyde, error) {
 	c := &Clyde{}
 
 	c.homeDir = dir
+	c.transport = t
+	c.clock = realClock{}
+	c.startTime = c.clock.Now()
 
-	// Set up zephyr session
-	c.session, err = zephyr.DialSystemDefault()
+	c.rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	// Load config and set up logging
+	c.config, err = c.loadConfig()
 	if err != nil {
 		return nil, err
 	}
-
-	// Create krb5 context for subscriptions
-	c.ctx, err = krb5.NewContext()
+	level, err := logger.ParseLevel(c.config.LogLevel)
 	if err != nil {
 		return nil, err
 	}
+	c.log = logger.New(os.Stderr, level)
 
 	// Create markov chain, and try to load saved chain
-	c.chain = markov.NewChain(prefixLen)
-	err = c.chain.Load(c.path(chainFile))
+	chain := markov.NewChain(c.config.PrefixLen)
+	err = c.loadChain(chain, chainFile)
 	if err != nil && !os.IsNotExist(err) {
 		return nil, err
 	}
+	c.chain = chain
 
-	// Create zsig markov chain, and try to load saved chain
-	c.zsigChain = markov.NewChain(zsigPrefixLen)
-	err = c.zsigChain.Load(c.path(zsigChainFile))
+	// Create zsig character-level markov chain, and try to load
+	// saved chain
+	zsigChain := markov.NewChainMode(zsigPrefixLen, markov.Runes)
+	err = c.loadChain(zsigChain, zsigChainFile)
 	if err != nil && !os.IsNotExist(err) {
 		return nil, err
 	}
+	c.zsigChain = zsigChain
+	setRand(c.chain, c.rng)
+	setRand(c.zsigChain, c.rng)
+	setNow(c.chain, c.clock.Now)
+	setNow(c.zsigChain, c.clock.Now)
 
-	c.session.SendSubscribeNoDefaults(c.ctx, []zephyr.Subscription{{Class: homeClass, Instance: homeInstance, Recipient: ""}})
-	c.subs = make(map[string]classPolicy)
+	// Load the list of words Clyde shouldn't say, if any
+	err = c.loadBlocklist()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	err = c.transport.Subscribe(c.baseSubs())
+	if err != nil {
+		return nil, err
+	}
+	c.subs = make(map[string]subscription)
 	err = c.loadSubs()
 	if err != nil && !os.IsNotExist(err) {
 		return nil, err
@@ -102,15 +171,27 @@ func LoadClyde(dir string) (*Clyde, error) {
 
 	c.mood = mood.Ok
 
-	c.lastInteraction = time.Now()
-	c.lastSaved = time.Now()
-
-	c.ticker = time.NewTicker(time.Minute)
-
 	c.cat = cat.Cat{}
+	c.cat.Name = c.config.CatName
 	c.cat.State = cat.Traveling
 
+	c.jitterLonelyAfter()
+
+	c.lastInteraction = c.clock.Now()
+	err = c.loadState()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	c.lastSaved = c.clock.Now()
+	c.publishState()
+
+	c.ticker = c.clock.NewTicker(tickInterval)
+
+	c.cooldowns = make(map[string]time.Time)
+
 	c.shutdown = make(chan struct{})
+	c.done = make(chan struct{})
+	c.reloads = make(chan chan error)
 
 	return c, nil
 }
@@ -120,6 +201,7 @@ func LoadClyde(dir string) (*Clyde, error) {
 // to clock ticks. After Clyde.Run() is called, Clyde.Shutdown() must
 // be called before exiting.
 func (c *Clyde) Run() {
+	c.running = true
 	c.wg.Add(1)
 	go func() {
 		defer c.handleShutdown()
@@ -132,15 +214,112 @@ func (c *Clyde) Run() {
 			default:
 			}
 			select {
-			case t := <-c.ticker.C:
-				c.handleTick(t)
-			case r := <-c.session.Messages():
-				c.handleMessage(r)
+			case t := <-c.ticker.C():
+				c.safely(func() { c.handleTick(t) })
+			case r, ok := <-c.transport.Messages():
+				if !ok {
+					err := c.reconnect()
+					if err == ErrNoReconnect {
+						c.log.Infof("Transport closed, shutting down")
+						return
+					}
+					if err != nil {
+						c.log.Errorf("Transport closed and couldn't reconnect, shutting down: %v", err)
+						return
+					}
+					continue
+				}
+				c.safely(func() { c.handleMessage(r) })
+			case errc := <-c.reloads:
+				errc <- c.reload()
 			case <-c.shutdown:
 				return
 			}
+			c.publishState()
+		}
+	}()
+}
+
+// safely runs f, recovering from any panic so that one bad message or
+// behavior doesn't take Clyde down. After a panic, Clyde saves
+// everything in case his state is too broken to keep running.
+func (c *Clyde) safely(f func()) {
+	defer func() {
+		if p := recover(); p != nil {
+			c.log.Errorf("Recovered from panic: %v\n%s", p, debug.Stack())
+			c.saveAll()
+			c.lastSaved = c.clock.Now()
 		}
 	}()
+	f()
+}
+
+// Done returns a channel that is closed once Clyde has stopped
+// running, either because Shutdown was called or because he lost his
+// zephyr session and couldn't reconnect. Shutdown must still be called
+// after Done is closed.
+func (c *Clyde) Done() <-chan struct{} {
+	return c.done
+}
+
+// reconnect tries to reconnect Clyde's transport, resubscribing to
+// all of his classes, giving up after a few attempts, if Clyde is
+// shutting down, or if the transport can't reconnect at all.
+func (c *Clyde) reconnect() error {
+	backoff := reconnectBackoff
+	var err error
+	for attempt := 1; attempt <= reconnectAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-c.clock.After(backoff):
+			case <-c.shutdown:
+				return errors.New("shutting down")
+			}
+			backoff *= 2
+		}
+
+		c.log.Infof("Reconnecting to zephyr (attempt %d)", attempt)
+		err = c.transport.Reconnect()
+		if err == ErrNoReconnect {
+			return err
+		}
+		if err != nil {
+			c.log.Warnf("Reconnect failed: %v", err)
+			continue
+		}
+
+		c.resubscribe()
+		return nil
+	}
+	return err
+}
+
+// baseSubs returns the subscriptions Clyde always has: his homes, and
+// his personals if enabled.
+func (c *Clyde) baseSubs() []zephyr.Subscription {
+	var subList []zephyr.Subscription
+	for _, h := range c.config.Homes {
+		subList = append(subList, zephyr.Subscription{Class: h.Class, Instance: h.Instance, Recipient: ""})
+	}
+	if c.config.Personals {
+		subList = append(subList, zephyr.Subscription{Class: personalClass, Instance: "*", Recipient: c.config.Principal})
+	}
+	return subList
+}
+
+// resubscribe subscribes Clyde's transport to his homes, his
+// personals, and all of the classes in his subscriptions.
+func (c *Clyde) resubscribe() {
+	subList := c.baseSubs()
+	for class, sub := range c.subs {
+		if sub.Policy != 0 {
+			subList = append(subList, zephyr.Subscription{Class: class, Instance: sub.Instance, Recipient: ""})
+		}
+	}
+	err := c.transport.Subscribe(subList)
+	if err != nil {
+		c.log.Errorf("Error resubscribing: %v", err)
+	}
 }
 
 // Shutdown tells Clyde to save his persistent state to his home
@@ -151,116 +330,401 @@ func (c *Clyde) Run() {
 func (c *Clyde) Shutdown() {
 	close(c.shutdown)
 	c.wg.Wait()
-	c.session.Close() // Moved here to avoid lingering internal event loop issue
+	c.transport.Close() // Moved here to avoid lingering internal event loop issue
 }
 
+// ShutdownWithTimeout is like Shutdown, but gives up and returns an
+// error if Clyde hasn't finished shutting down within d, e.g. because
+// he's stuck sending a message. Only one of Shutdown and
+// ShutdownWithTimeout may be called.
+func (c *Clyde) ShutdownWithTimeout(d time.Duration) error {
+	close(c.shutdown)
 
+	finished := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(d):
+		return fmt.Errorf("Clyde didn't shut down within %v", d)
+	}
+	return c.transport.Close()
+}
+
+
+// classPolicy determines how Clyde behaves on a class he's subscribed
+// to: LISTEN only learns from messages, REPLYHOME replies on Clyde's
+// home class unless addressed directly, REPLYHOMECLASS does the same
+// but keeps the original instance, and FULL replies on the class.
 type classPolicy uint8
 
 const (
 	LISTEN classPolicy = 1
 	REPLYHOME classPolicy = 2
 	FULL classPolicy = 3
+	REPLYHOMECLASS classPolicy = 4
 )
 
-// subscribe subscribes Clyde to a new zephyr class.
-func (c *Clyde) subscribe(class string, policy classPolicy) {
-	if c.subs[class] != 0 {
+var classPolicyNames = map[classPolicy]string{
+	LISTEN: "listen",
+	REPLYHOME: "replyhome",
+	FULL: "full",
+	REPLYHOMECLASS: "replyhomeclass",
+}
+
+// String returns the name of the policy, as used in subs.json.
+func (p classPolicy) String() string {
+	name, ok := classPolicyNames[p]
+	if !ok {
+		return fmt.Sprintf("classPolicy(%d)", uint8(p))
+	}
+	return name
+}
+
+// MarshalJSON encodes a policy as its name.
+func (p classPolicy) MarshalJSON() ([]byte, error) {
+	name, ok := classPolicyNames[p]
+	if !ok {
+		return nil, fmt.Errorf("invalid class policy %d", uint8(p))
+	}
+	return json.Marshal(name)
+}
+
+// UnmarshalJSON decodes a policy from its name, or from the integer
+// form used by older versions of subs.json, rejecting unknown values.
+// The integer 0, which older versions could save for a class Clyde
+// wasn't subscribed to, decodes as no policy.
+func (p *classPolicy) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err != nil {
+		var n uint8
+		if json.Unmarshal(b, &n) != nil {
+			return fmt.Errorf("invalid class policy %s", b)
+		}
+		if _, ok := classPolicyNames[classPolicy(n)]; !ok && n != 0 {
+			return fmt.Errorf("invalid class policy %d", n)
+		}
+		*p = classPolicy(n)
+		return nil
+	}
+
+	for policy, policyName := range classPolicyNames {
+		if name == policyName {
+			*p = policy
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid class policy %q", name)
+}
+
+// subscription is Clyde's subscription to a class: the policy he
+// follows there, and the instance he's confined to ("*" for all of
+// them). While Clyde has been told to be quiet on a class, his policy
+// there is LISTEN, and MutedPolicy holds the policy to restore.
+type subscription struct {
+	Policy classPolicy
+	Instance string
+	MutedPolicy classPolicy `json:",omitempty"`
+}
+
+// UnmarshalJSON decodes a subscription, also accepting the bare
+// policy used by older versions of subs.json, which means all
+// instances.
+func (s *subscription) UnmarshalJSON(b []byte) error {
+	var policy classPolicy
+	if policy.UnmarshalJSON(b) == nil {
+		*s = subscription{Policy: policy, Instance: "*"}
+		return nil
+	}
+
+	type plainSubscription subscription
+	var sub plainSubscription
+	if err := json.Unmarshal(b, &sub); err != nil {
+		return err
+	}
+	if sub.Instance == "" {
+		sub.Instance = "*"
+	}
+	*s = subscription(sub)
+	return nil
+}
+
+// covers reports whether a subscription includes the given instance.
+func (s subscription) covers(instance string) bool {
+	return s.Instance == "*" || strings.EqualFold(s.Instance, instance)
+}
+
+// policyFor returns Clyde's policy for the given class and instance,
+// or 0 if he isn't subscribed to them.
+func (c *Clyde) policyFor(class, instance string) classPolicy {
+	sub := c.subs[class]
+	if !sub.covers(instance) {
+		return 0
+	}
+	return sub.Policy
+}
+
+// Subscriptions returns a copy of Clyde's subscriptions (not
+// including his homes), mapping each class to the name of his policy
+// on it. It shouldn't be called concurrently with Clyde subscribing
+// to a new class.
+func (c *Clyde) Subscriptions() map[string]string {
+	subs := make(map[string]string)
+	for class, sub := range c.subs {
+		if sub.Policy != 0 {
+			subs[class] = sub.Policy.String()
+		}
+	}
+	return subs
+}
+
+// subscribe subscribes Clyde to a new zephyr class, on the given
+// instance or "*" for all of them.
+func (c *Clyde) subscribe(class, instance string, policy classPolicy) {
+	if c.subs[class].Policy != 0 {
 		return
 	}
-	c.session.SendSubscribeNoDefaults(c.ctx, []zephyr.Subscription{{Class: class, Instance: "*", Recipient: ""}})
-	c.subs[class] = policy
+	err := c.transport.Subscribe([]zephyr.Subscription{{Class: class, Instance: instance, Recipient: ""}})
+	if err != nil {
+		c.log.Errorf("Error subscribing to %s: %v", class, err)
+	}
+	c.subs[class] = subscription{Policy: policy, Instance: instance}
+
+	if c.config.GreetNewSubs && policy != LISTEN {
+		if instance == "*" {
+			instance = greetInstance
+		}
+		c.send(class, instance, "Hi, everyone! I'm Clyde :)")
+	}
 }
 
 // send sends a zephyr from Clyde with the given body to the given
 // class and instance. It delays based on the length of the message,
 // and alters the message based on Clyde's mood.
 func (c *Clyde) send(class, instance, body string) {
-	preformatted := false
+	c.sendTo(class, instance, "", body)
+}
+
+// sendPersonal sends a zephyr from Clyde back to the sender of a
+// personal zephyr, on the same class and instance.
+func (c *Clyde) sendPersonal(r zephyr.MessageReaderResult, body string) {
+	c.sendTo(r.Message.Header.Class, r.Message.Header.Instance, r.Message.Header.Sender, body)
+}
 
-	log.Printf("Sending message to -c %s -i %s: %s", class, instance, body)
+// sendTo is like send, but with an explicit recipient, which is empty
+// except for personals.
+func (c *Clyde) sendTo(class, instance, recipient, body string) {
+	preformatted := false
+	c.lastSent = body
 
-	time.Sleep(time.Duration(len(body))*sendDelayFactor*time.Millisecond)
+	c.log.Debugf("Sending message to -c %s -i %s: %s", class, instance, body)
 
-	if !preformatted {
-		body = stringutil.BreakLines(body, stringutil.MaxLine)
+	if c.config.MaxResponseRunes > 0 {
+		body = stringutil.Truncate(body, c.config.MaxResponseRunes)
 	}
 
-	if rand.Intn(10) == 0 {
-		log.Printf("Tweaking message for mood %v", c.mood)
-		format := "%s"
-		breaklines := true
-		switch c.mood {
-		case mood.Lonely:
-			format = "%s *sigh*"
-		case mood.Good:
-			format = "%s :)"
-		case mood.Angry:
-			format = "%s\n(╯°□°)╯︵ ┻━┻"
-			breaklines = false
-		case mood.Turnip:
-			body = "blub blub"
-		case mood.Great:
-			format = "*bounce* %s"
-		}
-		body = fmt.Sprintf(format, body)
-		if breaklines && !preformatted {
-			body = stringutil.BreakLines(body, stringutil.MaxLine)
-		}
+	c.clock.Sleep(time.Duration(len(body)*c.config.SendDelayFactor)*time.Millisecond)
+
+	breakLines := func(s string) string {
+		return stringutil.BreakLinesWidth(s, stringutil.MaxLine)
+	}
+	if preformatted {
+		breakLines = func(s string) string { return s }
 	}
+	body = breakLines(body)
 
-	uid := c.session.MakeUID(time.Now())
+	if c.rng.Intn(10) == 0 {
+		c.log.Debugf("Tweaking message for mood %v", c.mood)
+		body = decorateForMood(body, c.mood, breakLines)
+	}
 
 	var zsig string
-	if zsigUseChainer {
-		zsig = c.zsigChain.Generate("", 1, rand.Intn(6)+2)
+	if c.currentZsig != "" {
+		zsig = c.currentZsig
+	} else if c.config.ZsigUseChainer {
+		zsig = c.generateZsig()
 	} else {
 		zsig = "Clyde"
 	}
 
-	msg := &zephyr.Message{
-		Header: zephyr.Header{
-			Kind:	zephyr.ACKED,
-			UID:	uid,
-			Port:	c.session.Port(),
-			Class:	class, Instance: instance,
-			OpCode: "AUTO",
-			Sender:		sender,
-			Recipient:	"",
-			DefaultFormat:	"http://mit.edu/df/",
-			SenderAddress:	c.session.LocalAddr().IP,
-			Charset:	zephyr.CharsetUTF8,
-			OtherFields:	nil,
-		},
-		Body: []string{zsig, body},
-	}
-	_, err := c.session.SendMessageUnauth(msg)
-	if err != nil {
-		log.Printf("Send error: %v", err)
+	// Retry failed sends with exponential backoff, giving up early
+	// if Clyde is shutting down
+	backoff := sendRetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := c.transport.Send(class, instance, recipient, zsig, body)
+		if err == nil {
+			return
+		}
+		if attempt == sendAttempts {
+			c.log.Errorf("Send error, giving up after %d attempts: %v", attempt, err)
+			c.counters.sendFailed()
+			return
+		}
+		c.log.Warnf("Send error on attempt %d, retrying in %v: %v", attempt, backoff, err)
+		select {
+		case <-c.clock.After(backoff):
+		case <-c.shutdown:
+			c.log.Errorf("Shutting down, giving up on send")
+			c.counters.sendFailed()
+			return
+		}
+		backoff *= 2
+	}
+}
+
+// decorateForMood alters a message to reflect the given mood, e.g.
+// sighing when lonely, and rewraps its lines with breakLines unless
+// the decoration has its own line breaks. Moods without a decoration
+// leave the message unchanged.
+func decorateForMood(body string, m mood.Mood, breakLines func(string) string) string {
+	format := "%s"
+	breaklines := true
+	switch m {
+	case mood.Lonely:
+		format = "%s *sigh*"
+	case mood.Good:
+		format = "%s " + m.Emoji()
+	case mood.Angry:
+		format = "%s\n" + m.Emoji()
+		breaklines = false
+	case mood.Turnip:
+		body = "blub blub"
+	case mood.Great:
+		format = "*bounce* %s"
+	}
+	if format == "%s" {
+		return body
+	}
+	body = fmt.Sprintf(format, body)
+	if breaklines {
+		body = breakLines(body)
 	}
+	return body
+}
+
+// home returns Clyde's primary home class and instance.
+func (c *Clyde) home() Home {
+	return c.config.Homes[0]
+}
+
+// isHome reports whether the given class and instance are one of
+// Clyde's homes.
+func (c *Clyde) isHome(class, instance string) bool {
+	for _, h := range c.config.Homes {
+		if h.Class == class && h.Instance == instance {
+			return true
+		}
+	}
+	return false
+}
+
+// sendHome sends a zephyr from Clyde to his primary home.
+func (c *Clyde) sendHome(body string) {
+	h := c.home()
+	c.send(h.Class, h.Instance, body)
+}
+
+// jitterLonelyAfter picks how long this Clyde must be alone before
+// he can get lonely, adding up to LonelyJitter to LonelyAfter so that
+// several Clydes started together don't all get lonely at once.
+func (c *Clyde) jitterLonelyAfter() {
+	c.lonelyAfter = c.config.LonelyAfter.Duration
+	if jitter := c.config.LonelyJitter.Duration; jitter > 0 {
+		c.lonelyAfter += time.Duration(c.rng.Int63n(int64(jitter) + 1))
+	}
+	c.log.Debugf("Can get lonely after %v", c.lonelyAfter)
+}
+
+// SetRand replaces the random number generator Clyde uses for his
+// behaviors and chains, e.g. with a fixed-seed generator for
+// reproducible output. Clyde's loneliness jitter is chosen again
+// using the new generator.
+func (c *Clyde) SetRand(rng *rand.Rand) {
+	c.rng = rng
+	setRand(c.chain, rng)
+	setRand(c.zsigChain, rng)
+	c.jitterLonelyAfter()
 }
 
 func (c *Clyde) path(filename string) string {
 	return path.Join(c.homeDir, filename)
 }
 
+// writeFile replaces a file in Clyde's home directory with whatever
+// write writes. It writes to a temporary file and renames it into
+// place, so a failed or interrupted save never leaves a truncated
+// file behind.
+func (c *Clyde) writeFile(filename string, write func(io.Writer) error) error {
+	f, err := os.CreateTemp(c.homeDir, filename+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // no-op once renamed
 
+	err = write(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), c.path(filename))
+}
+
+// Clyde's default home, if none is configured
 const homeClass = "ztoys"
 const homeInstance = "clyde"
+const greetInstance = "personal" // where Clyde says hello on a class he subscribes to all of
+
+const personalClass = "message" // the class personal zephyrs are sent on
 
+const configFile = "config.json"
 const chainFile = "chain.json"
-const zsigChainFile = "zsigChain.json"
+const zsigChainFile = "zsigRuneChain.json" // zsigChain.json held an older word-level zsig chain
 const subsFile = "subs.json"
+const stateFile = "state.json"
+const blocklistFile = "blocklist.txt"
+
+const compressedSuffix = ".gz" // chains are saved gzipped, with this suffix added to their filenames
 
 const sender = "clyde"
-const prefixLen = 2
+const prefixLen = 2 // default number of words of context in the chain
+const maxPrefixLen = 5
+
+const zsigPrefixLen = 3 // characters of context used to generate zsigs
+const zsigMinRunes = 8
+const zsigMaxRunes = 30
+
+const sendDelayFactor = 20 // default milliseconds to wait per character in a message before sending
+const minSendDelayFactor = 2 // bounds on how fast or slow Clyde can be told to talk
+const maxSendDelayFactor = 160
+const maxResponseRunes = 700 // default cap on the length of a message, in characters
+
+const minChainWords = 3 // default number of words Clyde tries to add when generating a reply
 
-const zsigUseChainer = false
-const zsigPrefixLen = 1 // Be more creative with less input data
+const tickInterval = time.Minute // how often Clyde checks on his idle state
+const autosaveInterval = 5*time.Minute // default time between saves of what Clyde's learned while running
 
-const sendDelayFactor = 20 // milliseconds to wait per character in a message before sending
+const reconnectAttempts = 5 // number of times to try reconnecting a closed zephyr session
+const reconnectBackoff = time.Second // time to wait before retrying a failed reconnect; doubles with each attempt
+
+const sendAttempts = 3 // number of times to try sending a message
+const sendRetryBackoff = time.Second // time to wait before the first retry; doubles with each retry
 
 func (c *Clyde) handleMessage(r zephyr.MessageReaderResult) {
+	// Ignore anything we couldn't parse as a message
+	if r.Message == nil {
+		c.log.Warnf("received unparseable message")
+		return
+	}
+
 	// Ignore our own messages
 	if r.Message.Header.Sender == sender {
 		return
@@ -271,52 +735,132 @@ func (c *Clyde) handleMessage(r zephyr.MessageReaderResult) {
 		return
 	}
 
-	log.Printf("received message on -c %s -i %s: %s", r.Message.Header.Class, r.Message.Header.Instance, util.MessageBody(r))
+	c.log.Debugf("received message on -c %s -i %s: %s", r.Message.Header.Class, r.Message.Header.Instance, util.MessageBody(r))
+
+	c.counters.messageSeen()
+
+	personal := util.IsPersonal(r)
+	if personal && !c.config.Personals {
+		c.log.Debugf("ignoring personal from %s", r.Message.Header.Sender)
+		return
+	}
 
-	c.chain.Build(strings.NewReader(util.MessageBody(r)))
-	c.zsigChain.Build(strings.NewReader(util.MessageZSig(r)))
+	// Pings, auto-replies, and other control messages aren't chat,
+	// so don't respond to them (or learn from them, unless
+	// configured to)
+	opcode := r.Message.Header.OpCode
+	control := opcode != "" && !containsFold(c.config.AllowOpCodes, opcode)
+	learnable := !c.config.LearningPaused && c.config.learnAllowed(shortSender(r), r.AuthStatus == zephyr.AuthYes)
+	if !personal && learnable && (!control || c.config.LearnFromOpCodes) {
+		c.learn(r)
+	}
+	if control {
+		c.log.Debugf("ignoring message with opcode %s", opcode)
+		return
+	}
+
+	// Learn from everyone, but only respond to allowed senders
+	if !c.config.senderAllowed(shortSender(r)) {
+		c.log.Debugf("ignoring message from %s", shortSender(r))
+		return
+	}
+
+	// Don't keep replying the same way to the same thing from the
+	// same sender, in case it's another bot replying to us. People
+	// talking to Clyde directly are never a loop.
+	addressed := util.AddressedToClyde(r, sender)
+	ex := exchange{
+		sender: r.Message.Header.Sender,
+		class: r.Message.Header.Class,
+		hash: bodyHash(util.MessageBody(r)),
+		time: c.clock.Now(),
+	}
+	if !addressed && c.exchanges.looping(ex) {
+		c.log.Warnf("Looks like a loop with %s on -c %s, not replying", ex.sender, ex.class)
+		return
+	}
 
 	// Perform the first behavior that triggers, and exit
+	c.lastSent = ""
 	for i, b := range behaviors {
-		if b(c, r) {
-			log.Printf("Behavior %d triggered", i)
-			c.lastInteraction = time.Now()
+		c.matchedPattern = ""
+		if b.behavior(c, r) {
+			c.log.Infof("Behavior %d (%s) triggered", i, b.name)
+			c.counters.behaviorTriggered(i)
+			// Don't explain personals on a public class, or
+			// explain away the explanation
+			if !personal && b.name != "why" {
+				c.lastTriggered = triggered{
+					name: b.name,
+					pattern: c.matchedPattern,
+				}
+			}
+			c.lastInteraction = c.clock.Now()
+			if !addressed && c.lastSent != "" {
+				ex.reply = bodyHash(c.lastSent)
+				c.exchanges.record(ex)
+			}
 			return
 		}
 	}
 }
 
+// everyAbout randomly returns true about once per the given interval
+// when called on every tick.
+func (c *Clyde) everyAbout(interval time.Duration) bool {
+	ticks := int(interval / tickInterval)
+	return ticks <= 1 || c.rng.Intn(ticks) == 0
+}
+
+// learn feeds an incoming zephyr into Clyde's chains, without any
+// formatting markup.
+func (c *Clyde) learn(r zephyr.MessageReaderResult) {
+	body := stringutil.StripZephyrFormat(util.MessageBody(r))
+	zsig := stringutil.StripZephyrFormat(util.MessageZSig(r))
+	c.chain.Build(strings.NewReader(body))
+	c.zsigChain.Build(strings.NewReader(zsig))
+	if c.config.LearnZsigsIntoMainChain {
+		c.chain.Build(strings.NewReader(zsig))
+	}
+}
+
 func (c *Clyde) handleTick(t time.Time) {
-	if time.Since(c.lastSaved) > 30*time.Minute {
-		log.Println("Saving data")
-		c.chain.Save(c.path(chainFile))
-		c.zsigChain.Save(c.path(zsigChainFile))
-		c.saveSubs()
-		c.lastSaved = time.Now()
+	autosave := c.config.AutosaveInterval.Duration
+	if autosave > 0 && c.since(c.lastSaved) >= autosave {
+		c.log.Infof("Saving data")
+		c.saveAll()
+		c.lastSaved = c.clock.Now()
 	}
 
-	aloneDuration := time.Since(c.lastInteraction)
+	rotate := c.config.ZsigRotateInterval.Duration
+	if rotate > 0 && c.since(c.lastZsigRotation) >= rotate {
+		c.currentZsig = c.generateZsig()
+		c.lastZsigRotation = c.clock.Now()
+		c.log.Debugf("Rotated zsig to %q", c.currentZsig)
+	}
+
+	aloneDuration := c.since(c.lastInteraction)
 
-	log.Printf("Current alone duration: %v", aloneDuration)
+	c.log.Debugf("Current alone duration: %v", aloneDuration)
 
-	if aloneDuration >= time.Hour && rand.Intn(90) == 0 {
-		log.Printf("Alone for a while, sending message (current mood: %v)", c.mood)
+	if aloneDuration >= c.config.ChatterAfter.Duration && c.everyAbout(c.config.ChatterInterval.Duration) {
+		c.log.Infof("Alone for a while, sending message (current mood: %v)", c.mood)
 		var phrase string
 		switch c.mood {
 		case mood.Lonely:
-			if rand.Intn(6) == 0 {
-				log.Println("cat interaction")
+			if c.rng.Intn(6) == 0 {
+				c.log.Debugf("cat interaction (cat state %v)", c.cat.State)
 				switch c.cat.State {
 				case cat.Traveling:
-					log.Println("can't find cat")
-					c.send(homeClass, homeInstance, fmt.Sprintf("I can't find %s! :(", cat.CatName))
+					c.log.Infof("can't find cat")
+					c.sendHome(fmt.Sprintf("I can't find %s! :(", c.cat.Name))
 					c.mood = c.mood.Worse()
 				case cat.Normal:
-					if c.cat.Class != homeClass || c.cat.Instance != homeInstance {
-						log.Println("Trying to steal cat")
+					if !c.isHome(c.cat.Class, c.cat.Instance) {
+						c.log.Infof("Trying to steal cat")
 						tryScoopCat(c)
 					} else {
-						log.Println("Trying to play with cat")
+						c.log.Infof("Trying to play with cat")
 						tryPlayCat(c)
 					}
 				}
@@ -330,32 +874,119 @@ func (c *Clyde) handleTick(t time.Time) {
 			phrase = "*bounce*"
 		}
 		if phrase != "" {
-			c.send(homeClass, homeInstance, phrase)
+			c.sendHome(phrase)
 		}
 	}
-	if aloneDuration >= 2*time.Hour && rand.Intn(30) == 0 {
-		log.Println("getting lonely")
+	if aloneDuration >= c.lonelyAfter && c.everyAbout(c.config.LonelyInterval.Duration) {
+		c.log.Infof("getting lonely")
 		c.mood = mood.Lonely
 	}
 
-	if c.cat.Stolen && time.Since(c.cat.StolenTime) > cat.StealDuration {
-		log.Println("trying to return stolen cat")
+	if c.cat.Stolen && c.since(c.cat.StolenTime) > cat.StealDuration {
+		c.log.Infof("trying to return stolen cat")
 		tryScoopCat(c)
 	}
 }
 
 func (c *Clyde) handleShutdown() {
-	log.Println("Shutting down")
+	c.log.Infof("Shutting down")
 	c.ticker.Stop()
-	c.chain.Save(c.path(chainFile))
-	c.zsigChain.Save(c.path(zsigChainFile))
-	c.saveSubs()
-	c.session.SendCancelSubscriptions(c.ctx)
-	c.ctx.Free()
-	// c.session.Close()
+	c.saveAll()
+	err := c.transport.CancelSubscriptions()
+	if err != nil {
+		c.log.Errorf("Error cancelling subscriptions: %v", err)
+	}
+	// c.transport.Close()
+	close(c.done)
 	c.wg.Done()
 }
 
+// loadChain loads a saved chain from Clyde's home directory,
+// preferring the compressed version of the given file and falling back
+// to an uncompressed legacy save.
+func (c *Clyde) loadChain(chain persistentGenerator, filename string) error {
+	err := chain.Load(c.path(filename + compressedSuffix))
+	if os.IsNotExist(err) {
+		err = chain.Load(c.path(filename))
+	}
+	if _, ok := err.(*markov.PrefixLenError); ok {
+		c.log.Warnf("%v", err)
+		return nil
+	}
+	return err
+}
+
+// setPrefixLen rebuilds Clyde's chain with the given prefix length. It
+// returns an error if his chain isn't a markov chain, which can't be
+// rebuilt.
+func (c *Clyde) setPrefixLen(n int) error {
+	chain, ok := c.chain.(*markov.Chain)
+	if !ok {
+		return errors.New("can only change the prefix length of a markov chain")
+	}
+	if chain.PrefixLen() == n {
+		return nil
+	}
+	c.log.Infof("Rebuilding chain with prefix length %d", n)
+	c.chain = chain.Rebuild(n)
+	return nil
+}
+
+// saveAll saves Clyde's chains and subscriptions to his home
+// directory, logging any failures.
+func (c *Clyde) saveAll() {
+	err := saveGenerator(c.chain, c.path(chainFile + compressedSuffix))
+	if err != nil {
+		c.log.Errorf("Error saving chain: %v", err)
+	}
+	err = saveGenerator(c.zsigChain, c.path(zsigChainFile + compressedSuffix))
+	if err != nil {
+		c.log.Errorf("Error saving zsig chain: %v", err)
+	}
+	err = c.saveSubs()
+	if err != nil {
+		c.log.Errorf("Error saving subscriptions: %v", err)
+	}
+	err = c.saveState()
+	if err != nil {
+		c.log.Errorf("Error saving state: %v", err)
+	}
+}
+
+// loadBlocklist attempts to load a list of words, one per line, that
+// Clyde should never generate from a file in Clyde's home directory.
+func (c *Clyde) loadBlocklist() error {
+	words, err := c.readBlocklist()
+	if err != nil {
+		return err
+	}
+	setBlocklist(c.chain, words)
+	setBlocklist(c.zsigChain, words)
+	return nil
+}
+
+// readBlocklist reads Clyde's blocklist file without applying it.
+func (c *Clyde) readBlocklist() ([]string, error) {
+	f, err := os.Open(c.path(blocklistFile))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		word := strings.TrimSpace(scanner.Text())
+		if word != "" {
+			words = append(words, word)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return words, nil
+}
+
 // loadSubs attempts to load and subscribe to a list of subscriptions
 // in JSON format from a file in Clyde's home directory.
 func (c *Clyde) loadSubs() error {
@@ -372,31 +1003,74 @@ func (c *Clyde) loadSubs() error {
 	}
 
 	var subList []zephyr.Subscription
-	for class, policy := range c.subs {
-		if policy != 0 {
-			subList = append(subList, zephyr.Subscription{Class: class, Instance: "*", Recipient: ""})
+	for class, sub := range c.subs {
+		if sub.Policy == 0 {
+			delete(c.subs, class)
+			continue
 		}
+		subList = append(subList, zephyr.Subscription{Class: class, Instance: sub.Instance, Recipient: ""})
 	}
 
-	c.session.SendSubscribeNoDefaults(c.ctx, subList)
-
-	return nil
+	return c.transport.Subscribe(subList)
 }
 
 // saveSubs saves Clyde's subscriptions to a file in JSON format in
 // Clyde's home directory.
 func (c *Clyde) saveSubs() error {
-	f, err := os.Create(c.path(subsFile))
+	return c.writeFile(subsFile, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(c.subs)
+	})
+}
+
+// state holds miscellaneous state that Clyde saves across restarts.
+type state struct {
+	LastInteraction time.Time
+	CatPlaySuccesses map[string]int
+}
+
+// maxAloneDuration is the longest Clyde will believe he's been alone
+// after a restart; older saved interaction times are clamped to this.
+const maxAloneDuration = 7*24*time.Hour
+
+// loadState attempts to load Clyde's saved state from a file in JSON
+// format in Clyde's home directory.
+func (c *Clyde) loadState() error {
+	f, err := os.Open(c.path(stateFile))
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	enc := json.NewEncoder(f)
-	err = enc.Encode(c.subs)
+	var st state
+	dec := json.NewDecoder(f)
+	err = dec.Decode(&st)
 	if err != nil {
 		return err
 	}
 
+	// Don't trust timestamps from the future or the distant past
+	now := c.clock.Now()
+	switch {
+	case st.LastInteraction.After(now):
+		c.lastInteraction = now
+	case now.Sub(st.LastInteraction) > maxAloneDuration:
+		c.lastInteraction = now.Add(-maxAloneDuration)
+	default:
+		c.lastInteraction = st.LastInteraction
+	}
+
+	c.cat.PlaySuccesses = st.CatPlaySuccesses
+
 	return nil
 }
+
+// saveState saves Clyde's state to a file in JSON format in Clyde's
+// home directory.
+func (c *Clyde) saveState() error {
+	return c.writeFile(stateFile, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(state{
+			LastInteraction: c.lastInteraction,
+			CatPlaySuccesses: c.cat.PlaySuccesses,
+		})
+	})
+}
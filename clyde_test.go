@@ -0,0 +1,1206 @@
+// Copyright 2016 Sam Dukhovni <dukhovni@mit.edu>
+//
+// Licensed under the MIT License
+// (https://opensource.org/licenses/MIT)
+
+package clyde
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"github.com/zephyr-im/zephyr-go"
+	"github.com/sdukhovni/clyde-go/cat"
+	"github.com/sdukhovni/clyde-go/logger"
+	"github.com/sdukhovni/clyde-go/markov"
+	"github.com/sdukhovni/clyde-go/mood"
+	"github.com/sdukhovni/clyde-go/util"
+)
+
+// sentZephyr is a zephyr Clyde sent through a fakeTransport.
+type sentZephyr struct {
+	class, instance, recipient, zsig, body string
+}
+
+// fakeTransport is a Transport that records everything Clyde sends
+// and subscribes to, and delivers whatever is put on its messages
+// channel.
+type fakeTransport struct {
+	mu sync.Mutex
+	sent []sentZephyr
+	subs []zephyr.Subscription
+	messages chan zephyr.MessageReaderResult
+	sendCalls int
+	sendErrs []error // errors to return from the next calls to Send
+	reconnects int
+	reconnectErrs []error // results of the next calls to Reconnect
+	reconnected chan struct{}
+}
+
+func newFakeTransport() *fakeTransport {
+	return &fakeTransport{
+		messages: make(chan zephyr.MessageReaderResult),
+		reconnected: make(chan struct{}, 1),
+	}
+}
+
+func (t *fakeTransport) Send(class, instance, recipient, zsig, body string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sendCalls++
+	if len(t.sendErrs) > 0 {
+		err := t.sendErrs[0]
+		t.sendErrs = t.sendErrs[1:]
+		return err
+	}
+	t.sent = append(t.sent, sentZephyr{class, instance, recipient, zsig, body})
+	return nil
+}
+
+func (t *fakeTransport) Subscribe(subs []zephyr.Subscription) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.subs = append(t.subs, subs...)
+	return nil
+}
+
+func (t *fakeTransport) CancelSubscriptions() error {
+	return nil
+}
+
+func (t *fakeTransport) Messages() <-chan zephyr.MessageReaderResult {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.messages
+}
+
+// Reconnect returns the next of reconnectErrs, or ErrNoReconnect if
+// there are none left. On success, it replaces the messages channel
+// and signals reconnected.
+func (t *fakeTransport) Reconnect() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.reconnects++
+	if len(t.reconnectErrs) == 0 {
+		return ErrNoReconnect
+	}
+	err := t.reconnectErrs[0]
+	t.reconnectErrs = t.reconnectErrs[1:]
+	if err == nil {
+		t.messages = make(chan zephyr.MessageReaderResult)
+		t.reconnected <- struct{}{}
+	}
+	return err
+}
+
+// hangUp closes the messages channel, as if the session died.
+func (t *fakeTransport) hangUp() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	close(t.messages)
+}
+
+func (t *fakeTransport) Close() error {
+	return nil
+}
+
+// deliver gives Clyde a message, waiting until he receives it.
+func (t *fakeTransport) deliver(r zephyr.MessageReaderResult) {
+	t.mu.Lock()
+	messages := t.messages
+	t.mu.Unlock()
+	messages <- r
+}
+
+// sends returns everything sent so far, and forgets it.
+func (t *fakeTransport) sends() []sentZephyr {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sent := t.sent
+	t.sent = nil
+	return sent
+}
+
+// fakeClock is a Clock whose time only moves when told to. Sleeping
+// returns immediately, and its tickers never tick on their own.
+type fakeClock struct {
+	mu sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2016, time.March, 14, 15, 9, 26, 0, time.UTC)}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) Sleep(d time.Duration) {
+}
+
+// After advances the clock by d and returns a channel that already
+// has the new time on it.
+func (f *fakeClock) After(d time.Duration) <-chan time.Time {
+	f.Advance(d)
+	after := make(chan time.Time, 1)
+	after <- f.Now()
+	return after
+}
+
+func (f *fakeClock) NewTicker(d time.Duration) Ticker {
+	return fakeTicker{make(chan time.Time)}
+}
+
+// Advance moves the clock forward by d.
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+type fakeTicker struct {
+	c chan time.Time
+}
+
+func (t fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t fakeTicker) Stop() {
+}
+
+// newTestClyde returns a Clyde in a fresh home directory with the
+// given config file contents (if any), talking to a fakeTransport and
+// using a fakeClock and a fixed-seed random number generator.
+func newTestClyde(t *testing.T, config string) (*Clyde, *fakeTransport, *fakeClock) {
+	t.Helper()
+	dir := t.TempDir()
+	if config != "" {
+		err := os.WriteFile(path.Join(dir, configFile), []byte(config), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return loadTestClyde(t, dir)
+}
+
+// loadTestClyde is like newTestClyde, but loads Clyde from an existing
+// home directory.
+func loadTestClyde(t *testing.T, dir string) (*Clyde, *fakeTransport, *fakeClock) {
+	t.Helper()
+	ft := newFakeTransport()
+	c, err := NewClydeWithTransport(dir, ft)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.log = logger.New(io.Discard, logger.Debug)
+	clock := newFakeClock()
+	c.SetClock(clock)
+	c.SetRand(rand.New(rand.NewSource(1)))
+	return c, ft, clock
+}
+
+// message returns an authenticated zephyr from sender on the given
+// class and instance.
+func message(sender, class, instance, body string) zephyr.MessageReaderResult {
+	return zephyr.MessageReaderResult{
+		Message: &zephyr.Message{
+			Header: zephyr.Header{
+				Class: class,
+				Instance: instance,
+				Sender: sender + "@ATHENA.MIT.EDU",
+			},
+			Body: []string{"", body},
+		},
+		AuthStatus: zephyr.AuthYes,
+	}
+}
+
+// homeMessage returns an authenticated zephyr from a user on Clyde's
+// default home.
+func homeMessage(body string) zephyr.MessageReaderResult {
+	return message("alice", homeClass, homeInstance, body)
+}
+
+// reply feeds Clyde a zephyr and returns the body of his only reply,
+// failing if he doesn't reply exactly once.
+func reply(t *testing.T, c *Clyde, ft *fakeTransport, r zephyr.MessageReaderResult) string {
+	t.Helper()
+	c.handleMessage(r)
+	sent := ft.sends()
+	if len(sent) != 1 {
+		t.Fatalf("%q: got %d replies %v, want 1", r.Message.Body, len(sent), sent)
+	}
+	return sent[0].body
+}
+
+// unwrap undoes the line breaks Clyde adds to long messages.
+func unwrap(body string) string {
+	return strings.Join(strings.Fields(body), " ")
+}
+
+// noReply feeds Clyde a zephyr and fails if he replies.
+func noReply(t *testing.T, c *Clyde, ft *fakeTransport, r zephyr.MessageReaderResult) {
+	t.Helper()
+	c.handleMessage(r)
+	if sent := ft.sends(); len(sent) != 0 {
+		t.Fatalf("%q: got replies %v, want none", r.Message.Body, sent)
+	}
+}
+
+func TestLoadChain(t *testing.T) {
+	c := &Clyde{homeDir: t.TempDir()}
+	legacy := markov.NewChain(prefixLen)
+	legacy.Build(strings.NewReader("the cat sat on the mat."))
+	if err := legacy.Save(c.path(chainFile)); err != nil {
+		t.Fatal(err)
+	}
+
+	chain := markov.NewChain(prefixLen)
+	if err := c.loadChain(chain, chainFile); err != nil {
+		t.Fatal(err)
+	}
+	if chain.Size() != legacy.Size() {
+		t.Errorf("loaded legacy chain with %d prefixes, want %d", chain.Size(), legacy.Size())
+	}
+
+	// A compressed save is preferred over a legacy one
+	compressed := markov.NewChain(prefixLen)
+	compressed.Build(strings.NewReader("the dog ate the cat's dinner."))
+	if err := compressed.Save(c.path(chainFile + compressedSuffix)); err != nil {
+		t.Fatal(err)
+	}
+	chain = markov.NewChain(prefixLen)
+	if err := c.loadChain(chain, chainFile); err != nil {
+		t.Fatal(err)
+	}
+	if chain.Size() != compressed.Size() {
+		t.Errorf("loaded chain with %d prefixes, want %d", chain.Size(), compressed.Size())
+	}
+}
+
+func TestBlocklist(t *testing.T) {
+	c := &Clyde{homeDir: t.TempDir(), chain: markov.NewChain(1), zsigChain: markov.NewChain(1)}
+	if err := c.loadBlocklist(); !os.IsNotExist(err) {
+		t.Errorf("loadBlocklist with no blocklist returned %v", err)
+	}
+
+	err := os.WriteFile(c.path(blocklistFile), []byte("darn\n\n  heck  \n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.loadBlocklist(); err != nil {
+		t.Fatal(err)
+	}
+	c.chain.Build(strings.NewReader("oh darn oh heck"))
+	if got := c.chain.(*markov.Chain).NextWord(markov.Prefix{"oh"}); got != "" {
+		t.Errorf("NextWord = %q, want generation to stop", got)
+	}
+}
+
+func TestOpCodesNotLearned(t *testing.T) {
+	c := &Clyde{
+		log: logger.New(io.Discard, logger.Error),
+		chain: markov.NewChain(prefixLen),
+		zsigChain: markov.NewChain(prefixLen),
+	}
+	ping := homeMessage("the cat sat on the mat")
+	ping.Message.Header.OpCode = "PING"
+	c.handleMessage(ping)
+	if chainSize(c) != 0 {
+		t.Error("Clyde learned from a PING")
+	}
+
+	c.config.LearnFromOpCodes = true
+	c.handleMessage(ping)
+	if chainSize(c) == 0 {
+		t.Error("Clyde didn't learn from a PING with LearnFromOpCodes set")
+	}
+}
+
+// chainSize returns the number of prefixes in Clyde's chain.
+func chainSize(c *Clyde) int {
+	return c.chain.(sizedGenerator).Size()
+}
+
+func TestSaveCompressedChain(t *testing.T) {
+	c, _, _ := newTestClyde(t, "")
+	c.learn(homeMessage("the cat sat on the mat."))
+	size := chainSize(c)
+	c.saveAll()
+
+	b, err := os.ReadFile(c.path(chainFile + compressedSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(b), "\x1f\x8b") {
+		t.Errorf("%s isn't gzipped", chainFile+compressedSuffix)
+	}
+	if _, err := os.Stat(c.path(chainFile)); !os.IsNotExist(err) {
+		t.Errorf("saved an uncompressed %s too", chainFile)
+	}
+
+	loaded, _, _ := loadTestClyde(t, c.homeDir)
+	if chainSize(loaded) != size {
+		t.Errorf("loaded chain with %d prefixes, want %d", chainSize(loaded), size)
+	}
+}
+
+func TestLoadLegacyChain(t *testing.T) {
+	dir := t.TempDir()
+	legacy := markov.NewChain(prefixLen)
+	legacy.Build(strings.NewReader("the cat sat on the mat."))
+	err := legacy.Save(path.Join(dir, chainFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, _, _ := loadTestClyde(t, dir)
+	if chainSize(c) != legacy.Size() {
+		t.Errorf("loaded chain with %d prefixes, want %d", chainSize(c), legacy.Size())
+	}
+}
+
+func TestLoadBlocklist(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(path.Join(dir, blocklistFile), []byte("darn\n\n  heck  \n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _, _ := loadTestClyde(t, dir)
+	c.learn(homeMessage("oh darn oh heck oh darn"))
+	for i := 0; i < 20; i++ {
+		if got := c.chain.Generate("oh", 1, 5); strings.Contains(got, "darn") || strings.Contains(got, "heck") {
+			t.Fatalf("generated blocked word in %q", got)
+		}
+	}
+}
+
+// twoHomes is a config file giving Clyde two homes.
+const twoHomes = `{"Homes": [{"Class": "home1", "Instance": "clyde"}, {"Class": "home2", "Instance": "chat"}]}`
+
+func TestHomesSubscribed(t *testing.T) {
+	_, ft, _ := newTestClyde(t, twoHomes)
+	for _, home := range []zephyr.Subscription{{Class: "home1", Instance: "clyde"}, {Class: "home2", Instance: "chat"}} {
+		found := false
+		for _, sub := range ft.subs {
+			found = found || sub == home
+		}
+		if !found {
+			t.Errorf("not subscribed to home %v", home)
+		}
+	}
+}
+
+func TestOpCodes(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	ping := homeMessage("clyde, roll 2d6")
+	ping.Message.Header.OpCode = "PING"
+	noReply(t, c, ft, ping)
+	if chainSize(c) != 0 {
+		t.Error("Clyde learned from a PING")
+	}
+
+	reply(t, c, ft, homeMessage("clyde, roll 2d6"))
+	if chainSize(c) == 0 {
+		t.Error("Clyde didn't learn from an ordinary message")
+	}
+}
+
+func TestAllowOpCodes(t *testing.T) {
+	c, ft, _ := newTestClyde(t, `{"AllowOpCodes": ["auto"], "LearnFromOpCodes": true}`)
+	auto := homeMessage("clyde, roll 2d6")
+	auto.Message.Header.OpCode = "AUTO"
+	reply(t, c, ft, auto)
+
+	ping := homeMessage("the cat sat on the mat")
+	ping.Message.Header.OpCode = "PING"
+	size := chainSize(c)
+	noReply(t, c, ft, ping)
+	if chainSize(c) == size {
+		t.Error("Clyde didn't learn from a PING with LearnFromOpCodes set")
+	}
+}
+
+func TestLoadBadSubs(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(path.Join(dir, subsFile), []byte(`{"foo": 9}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewClydeWithTransport(dir, newFakeTransport()); err == nil {
+		t.Error("loaded subs with an invalid policy")
+	}
+}
+
+func TestLearnZsigs(t *testing.T) {
+	tests := []struct {
+		config string
+		mainLearnsZsig, generatesZsig bool
+	}{
+		{`{}`, false, false},
+		{`{"ZsigUseChainer": true}`, false, true},
+		{`{"LearnZsigsIntoMainChain": true}`, true, false},
+		{`{"ZsigUseChainer": true, "LearnZsigsIntoMainChain": true}`, true, true},
+	}
+	for _, test := range tests {
+		c, ft, _ := newTestClyde(t, test.config)
+		r := homeMessage("hello there friend")
+		c.learn(r)
+		bodyOnly := chainSize(c)
+		r.Message.Body[0] = "purple monkey dishwasher"
+		c.learn(r)
+		if learned := chainSize(c) > bodyOnly; learned != test.mainLearnsZsig {
+			t.Errorf("%s: main chain learned zsig: %v", test.config, learned)
+		}
+		if c.zsigChain.(sizedGenerator).Size() == 0 {
+			t.Errorf("%s: zsig chain didn't learn zsig", test.config)
+		}
+
+		c.sendHome("hi")
+		sent := ft.sends()
+		if len(sent) != 1 {
+			t.Fatalf("%s: sent %v", test.config, sent)
+		}
+		if generated := sent[0].zsig != "Clyde"; generated != test.generatesZsig {
+			t.Errorf("%s: sent with zsig %q", test.config, sent[0].zsig)
+		}
+	}
+}
+
+func TestShortBody(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	for _, body := range [][]string{{""}, {}, nil} {
+		r := homeMessage("")
+		r.Message.Body = body
+		noReply(t, c, ft, r)
+	}
+
+	// A lone body field is the body, with no zsig
+	r := homeMessage("")
+	r.Message.Body = []string{"clyde, roll 1d1"}
+	if got := reply(t, c, ft, r); got != "1" {
+		t.Errorf("got %q", got)
+	}
+
+	r = message(cat.CatName, "cats", "lounge", "")
+	r.Message.Body = []string{"zeroday purrs"}
+	noReply(t, c, ft, r)
+}
+
+// personal returns an authenticated personal zephyr from sender to
+// Clyde.
+func personal(sender, body string) zephyr.MessageReaderResult {
+	r := message(sender, personalClass, "personal", body)
+	r.Message.Header.Recipient = "clyde@ATHENA.MIT.EDU"
+	return r
+}
+
+func TestPersonals(t *testing.T) {
+	c, ft, _ := newTestClyde(t, `{"Personals": true, "Principal": "clyde@ATHENA.MIT.EDU"}`)
+	found := false
+	for _, sub := range ft.subs {
+		found = found || (sub.Class == personalClass && sub.Recipient == "clyde@ATHENA.MIT.EDU")
+	}
+	if !found {
+		t.Errorf("not subscribed to personals: %v", ft.subs)
+	}
+
+	c.handleMessage(personal("alice", "roll 1d1"))
+	sent := ft.sends()
+	if len(sent) != 1 || sent[0].recipient != "alice@ATHENA.MIT.EDU" || sent[0].class != personalClass || sent[0].body != "1" {
+		t.Errorf("replied to a personal with %v", sent)
+	}
+
+	c.handleMessage(homeMessage("roll 1d1"))
+	sent = ft.sends()
+	if len(sent) != 1 || sent[0].recipient != "" || sent[0].class != homeClass {
+		t.Errorf("replied to a class message with %v", sent)
+	}
+}
+
+func TestPersonalsDisabled(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	noReply(t, c, ft, personal("alice", "roll 1d1"))
+	for _, sub := range ft.subs {
+		if sub.Recipient != "" {
+			t.Errorf("subscribed to personals %v", sub)
+		}
+	}
+}
+
+func TestPersonalsNeedPrincipal(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(path.Join(dir, configFile), []byte(`{"Personals": true}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewClydeWithTransport(dir, newFakeTransport()); err == nil {
+		t.Error("enabled personals without a principal")
+	}
+}
+
+func TestInstanceSubscription(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	if got := reply(t, c, ft, homeMessage("clyde, subscribe to -c fun -i games")); got != "-c fun -i games sounds awesome! Thanks for the invitation :)" {
+		t.Errorf("got %q", got)
+	}
+	if last := ft.subs[len(ft.subs)-1]; last.Class != "fun" || last.Instance != "games" {
+		t.Errorf("subscribed to %v", last)
+	}
+
+	if got := reply(t, c, ft, message("alice", "fun", "games", "clyde, roll 1d1")); got != "1" {
+		t.Errorf("got %q", got)
+	}
+	noReply(t, c, ft, message("alice", "fun", "work", "clyde, roll 1d1"))
+
+	if err := c.saveSubs(); err != nil {
+		t.Fatal(err)
+	}
+	c, _, _ = loadTestClyde(t, path.Dir(c.path(subsFile)))
+	if want := (subscription{Policy: REPLYHOME, Instance: "games"}); c.subs["fun"] != want {
+		t.Errorf("loaded subscription %v, want %v", c.subs["fun"], want)
+	}
+}
+
+func TestLearnStripsFormat(t *testing.T) {
+	c, _, _ := newTestClyde(t, "")
+	c.learn(homeMessage("@b[look] at @i{this}"))
+	for i := 0; i < 10; i++ {
+		if got := c.chain.Generate("", 1, 10); strings.Contains(got, "@") {
+			t.Fatalf("generated markup in %q", got)
+		}
+	}
+}
+
+// blockingTransport is a fakeTransport whose sends block until
+// unblock is closed.
+type blockingTransport struct {
+	*fakeTransport
+	sending chan struct{}
+	unblock chan struct{}
+}
+
+func (t *blockingTransport) Send(class, instance, recipient, zsig, body string) error {
+	t.sending <- struct{}{}
+	<-t.unblock
+	return t.fakeTransport.Send(class, instance, recipient, zsig, body)
+}
+
+func TestShutdownWithTimeout(t *testing.T) {
+	c, _, _ := newTestClyde(t, "")
+	c.Run()
+	if err := c.ShutdownWithTimeout(5 * time.Second); err != nil {
+		t.Errorf("ShutdownWithTimeout: %v", err)
+	}
+}
+
+func TestShutdownTimesOut(t *testing.T) {
+	bt := &blockingTransport{newFakeTransport(), make(chan struct{}), make(chan struct{})}
+	c, err := NewClydeWithTransport(t.TempDir(), bt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.log = logger.New(io.Discard, logger.Debug)
+	c.SetClock(newFakeClock())
+	c.Run()
+	bt.deliver(homeMessage("clyde, roll 1d1"))
+	<-bt.sending
+
+	if err := c.ShutdownWithTimeout(10 * time.Millisecond); err == nil {
+		t.Error("ShutdownWithTimeout returned while Clyde was stuck sending")
+	}
+
+	// Let Clyde finish, so he isn't still running when the test
+	// cleans up his home directory
+	close(bt.unblock)
+	<-c.Done()
+}
+
+func TestStateLastInteraction(t *testing.T) {
+	c, _, clock := newTestClyde(t, "")
+	saved := clock.Now().Add(-3*time.Hour)
+	c.lastInteraction = saved
+	err := c.saveState()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.lastInteraction = clock.Now()
+	err = c.loadState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.lastInteraction.Equal(saved) {
+		t.Errorf("loaded lastInteraction %v, want %v", c.lastInteraction, saved)
+	}
+
+	// Timestamps from the future or the distant past are clamped
+	tests := []struct {
+		saved, want time.Time
+	}{
+		{clock.Now().Add(time.Hour), clock.Now()},
+		{clock.Now().Add(-10*maxAloneDuration), clock.Now().Add(-maxAloneDuration)},
+	}
+	for _, test := range tests {
+		c.lastInteraction = test.saved
+		if err := c.saveState(); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.loadState(); err != nil {
+			t.Fatal(err)
+		}
+		if !c.lastInteraction.Equal(test.want) {
+			t.Errorf("saved %v, loaded %v, want %v", test.saved, c.lastInteraction, test.want)
+		}
+	}
+}
+
+func TestIdleChatter(t *testing.T) {
+	c, ft, clock := newTestClyde(t, `{"ChatterAfter": "10m", "ChatterInterval": "1m", "LonelyAfter": "100h"}`)
+	c.mood = mood.Good
+	c.lastInteraction = clock.Now()
+
+	for i := 1; i < 10; i++ {
+		clock.Advance(tickInterval)
+		c.handleTick(clock.Now())
+		if sent := ft.sends(); len(sent) != 0 {
+			t.Fatalf("chattered %v after %d ticks alone", sent, i)
+		}
+	}
+	clock.Advance(tickInterval)
+	c.handleTick(clock.Now())
+	sent := ft.sends()
+	if len(sent) != 1 || sent[0].body != "Hi, all." || sent[0].class != homeClass {
+		t.Errorf("after 10 ticks alone, sent %v", sent)
+	}
+}
+
+func TestIdleChatterDefault(t *testing.T) {
+	c, ft, clock := newTestClyde(t, "")
+	c.mood = mood.Good
+	c.lastInteraction = clock.Now()
+	for i := 0; i < 30; i++ {
+		clock.Advance(tickInterval)
+		c.handleTick(clock.Now())
+	}
+	if sent := ft.sends(); len(sent) != 0 {
+		t.Errorf("chattered %v within half an hour by default", sent)
+	}
+}
+
+func TestSetPrefixLen(t *testing.T) {
+	c, ft, _ := newTestClyde(t, `{"AdminSenders": ["alice"]}`)
+	c.learn(homeMessage("the cat sat on the mat."))
+	if got := reply(t, c, ft, message("bob", homeClass, homeInstance, "clyde, use 3-word prefixes")); got != "You look sketchy, I don't trust you..." {
+		t.Errorf("non-admin got %q", got)
+	}
+	if got := reply(t, c, ft, homeMessage("clyde, use 3-word prefixes")); got != "Ok, now I'm thinking 3 words at a time." {
+		t.Errorf("admin got %q", got)
+	}
+	if n := c.chain.(*markov.Chain).PrefixLen(); n != 3 {
+		t.Errorf("chain has prefix length %d", n)
+	}
+	c.saveAll()
+
+	// A saved chain with another prefix length is rebuilt, with a
+	// warning, rather than failing to load
+	err := os.WriteFile(c.path(configFile), []byte(`{"PrefixLen": 1}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, _, _ := loadTestClyde(t, c.homeDir)
+	if n := loaded.chain.(*markov.Chain).PrefixLen(); n != 1 {
+		t.Errorf("loaded chain has prefix length %d", n)
+	}
+	if chainSize(loaded) == 0 {
+		t.Error("loaded an empty chain")
+	}
+}
+
+func TestMaxResponseRunes(t *testing.T) {
+	c, ft, _ := newTestClyde(t, `{"MaxResponseRunes": 20}`)
+	if got := reply(t, c, ft, homeMessage("clyde, echo "+strings.Repeat("meow ", 50))); got != "meow meow meow meow…" {
+		t.Errorf("got %q", got)
+	}
+
+	c.sendHome("short and sweet")
+	if sent := ft.sends(); len(sent) != 1 || sent[0].body != "short and sweet" {
+		t.Errorf("sent %v", sent)
+	}
+}
+
+func TestDecorateForMood(t *testing.T) {
+	unwrapped := func(s string) string { return s }
+	tests := []struct {
+		m mood.Mood
+		want string
+	}{
+		{mood.Ok, "hello"},
+		{mood.Good, "hello :)"},
+		{mood.Angry, "hello\n(╯°□°)╯︵ ┻━┻"},
+		{mood.Lonely, "hello *sigh*"},
+		{mood.Great, "*bounce* hello"},
+		{mood.Turnip, "blub blub"},
+	}
+	for _, test := range tests {
+		if got := decorateForMood("hello", test.m, unwrapped); got != test.want {
+			t.Errorf("%v: got %q, want %q", test.m, got, test.want)
+		}
+	}
+}
+
+func TestDecorateForMoodBreaksLines(t *testing.T) {
+	broke := false
+	breakLines := func(s string) string {
+		broke = true
+		return s
+	}
+	for _, m := range mood.All() {
+		broke = false
+		got := decorateForMood("hello", m, breakLines)
+		switch m {
+		case mood.Yucky, mood.Unhappy, mood.Ok:
+			if got != "hello" || broke {
+				t.Errorf("%v: got %q, broke lines %v", m, got, broke)
+			}
+		case mood.Turnip:
+			if got != "blub blub" || broke {
+				t.Errorf("%v: got %q, broke lines %v", m, got, broke)
+			}
+		case mood.Angry:
+			// The table flip goes on its own line, unwrapped
+			if broke {
+				t.Errorf("%v: broke lines", m)
+			}
+		default:
+			if !broke {
+				t.Errorf("%v: didn't break lines", m)
+			}
+		}
+	}
+}
+
+func TestLearnAllowed(t *testing.T) {
+	unauth := message("mallory", homeClass, homeInstance, "the cat sat on the mat.")
+	unauth.AuthStatus = zephyr.AuthNo
+	tests := []struct {
+		config string
+		r zephyr.MessageReaderResult
+		learns bool
+	}{
+		{`{}`, homeMessage("the cat sat on the mat."), true},
+		{`{}`, unauth, true},
+		{`{"LearnOnlyAuthenticated": true}`, homeMessage("the cat sat on the mat."), true},
+		{`{"LearnOnlyAuthenticated": true}`, unauth, false},
+		{`{"LearnFromSenders": ["Alice"]}`, homeMessage("the cat sat on the mat."), true},
+		{`{"LearnFromSenders": ["alice"]}`, message("bob", homeClass, homeInstance, "the cat sat on the mat."), false},
+		{`{"LearnFromSenders": ["mallory"], "LearnOnlyAuthenticated": true}`, unauth, false},
+	}
+	for _, test := range tests {
+		c, ft, _ := newTestClyde(t, test.config)
+		c.handleMessage(test.r)
+		ft.sends()
+		if learned := chainSize(c) > 0; learned != test.learns {
+			t.Errorf("%s from %s: learned %v, want %v", test.config, test.r.Message.Header.Sender, learned, test.learns)
+		}
+	}
+
+	// Clyde still talks to senders he won't learn from
+	c, ft, _ := newTestClyde(t, `{"LearnOnlyAuthenticated": true}`)
+	unauth.Message.Body[1] = "clyde, roll 1d1"
+	if got := reply(t, c, ft, unauth); got != "1" {
+		t.Errorf("replied to an unauthenticated roll with %q", got)
+	}
+}
+
+func TestReplyHomeClassSubs(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(path.Join(dir, subsFile), []byte(`{"games": {"Policy": "replyhomeclass", "Instance": "chess"}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, ft, _ := loadTestClyde(t, dir)
+	if want := (subscription{Policy: REPLYHOMECLASS, Instance: "chess"}); c.subs["games"] != want {
+		t.Fatalf("loaded subscription %v, want %v", c.subs["games"], want)
+	}
+
+	c.handleMessage(message("alice", "games", "chess", "roll 1d1"))
+	if sent := ft.sends(); len(sent) != 1 || sent[0].class != homeClass || sent[0].instance != "chess" {
+		t.Errorf("replied %v, want a reply on -c %s -i chess", sent, homeClass)
+	}
+	noReply(t, c, ft, message("alice", "games", "go", "roll 1d1"))
+
+	if err := c.saveSubs(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(c.path(subsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"replyhomeclass"`) {
+		t.Errorf("saved subs %s", b)
+	}
+}
+
+func TestRouting(t *testing.T) {
+	type dest struct {
+		class, instance string
+	}
+	tests := []struct {
+		policy classPolicy
+		body string
+		want *dest
+	}{
+		{FULL, "roll 2d6", &dest{"other", "foo"}},
+		{REPLYHOME, "roll 2d6", &dest{"home1", "clyde"}},
+		{REPLYHOME, "clyde, roll 2d6", &dest{"other", "foo"}},
+		{REPLYHOMECLASS, "roll 2d6", &dest{"home1", "foo"}},
+		{REPLYHOMECLASS, "clyde, roll 2d6", &dest{"other", "foo"}},
+		{LISTEN, "clyde, roll 2d6", nil},
+	}
+	for _, test := range tests {
+		c, ft, _ := newTestClyde(t, twoHomes)
+		c.subs["other"] = subscription{Policy: test.policy, Instance: "*"}
+		c.handleMessage(message("alice", "other", "foo", test.body))
+		sent := ft.sends()
+		if test.want == nil {
+			if len(sent) != 0 {
+				t.Errorf("%v, %q: replied %v, want no reply", test.policy, test.body, sent)
+			}
+			continue
+		}
+		if len(sent) != 1 || sent[0].class != test.want.class || sent[0].instance != test.want.instance {
+			t.Errorf("%v, %q: replied %v, want a reply on %v", test.policy, test.body, sent, *test.want)
+		}
+	}
+
+	// Clyde replies in place on any of his homes
+	c, ft, _ := newTestClyde(t, twoHomes)
+	c.handleMessage(message("alice", "home2", "chat", "roll 2d6"))
+	if sent := ft.sends(); len(sent) != 1 || sent[0].class != "home2" || sent[0].instance != "chat" {
+		t.Errorf("on the second home: replied %v", sent)
+	}
+}
+
+func TestClassPolicyJSON(t *testing.T) {
+	for _, p := range []classPolicy{LISTEN, REPLYHOME, FULL, REPLYHOMECLASS} {
+		b, err := json.Marshal(p)
+		if err != nil {
+			t.Fatalf("%v: %v", p, err)
+		}
+		if string(b) != `"`+p.String()+`"` {
+			t.Errorf("%v encoded as %s", p, b)
+		}
+		var got classPolicy
+		if err := json.Unmarshal(b, &got); err != nil || got != p {
+			t.Errorf("%s decoded as %v, %v", b, got, err)
+		}
+	}
+
+	for _, bad := range []string{`"loud"`, `7`, `-1`, `true`} {
+		var p classPolicy
+		if err := json.Unmarshal([]byte(bad), &p); err == nil {
+			t.Errorf("%s decoded as %v, want error", bad, p)
+		}
+	}
+	if _, err := json.Marshal(classPolicy(7)); err == nil {
+		t.Error("encoded an invalid policy")
+	}
+}
+
+func TestSubscriptions(t *testing.T) {
+	c, _, _ := newTestClyde(t, "")
+	c.subs["fun"] = subscription{Policy: FULL, Instance: "*"}
+	c.subs["games"] = subscription{Policy: REPLYHOMECLASS, Instance: "chess"}
+	c.subs["gone"] = subscription{}
+
+	subs := c.Subscriptions()
+	want := map[string]string{"fun": "full", "games": "replyhomeclass"}
+	if !reflect.DeepEqual(subs, want) {
+		t.Errorf("Subscriptions() = %v, want %v", subs, want)
+	}
+
+	subs["fun"] = "listen"
+	delete(subs, "games")
+	subs["new"] = "full"
+	if c.subs["fun"].Policy != FULL || c.subs["games"].Policy != REPLYHOMECLASS || c.subs["new"].Policy != 0 {
+		t.Errorf("changing Subscriptions() changed Clyde's subscriptions: %v", c.subs)
+	}
+}
+
+func TestGreetNewSubs(t *testing.T) {
+	c, ft, _ := newTestClyde(t, `{"GreetNewSubs": true}`)
+	c.handleMessage(homeMessage("clyde, subscribe to -c fun"))
+	sent := ft.sends()
+	if len(sent) != 2 || sent[0].class != "fun" || sent[0].instance != greetInstance || sent[0].body != "Hi, everyone! I'm Clyde :)" {
+		t.Fatalf("subscribing to a class sent %v", sent)
+	}
+	if sent[1].class != homeClass || sent[1].body != "-c fun sounds awesome! Thanks for the invitation :)" {
+		t.Errorf("replied %v", sent[1])
+	}
+
+	c.handleMessage(homeMessage("clyde, subscribe to -c games -i chess"))
+	if sent := ft.sends(); len(sent) != 2 || sent[0].class != "games" || sent[0].instance != "chess" {
+		t.Errorf("subscribing to an instance sent %v", sent)
+	}
+
+	// Already subscribed, so no greeting
+	if got := reply(t, c, ft, homeMessage("clyde, subscribe to -c fun")); got != "I'm already subbed to -c fun!" {
+		t.Errorf("resubscribing: got %q", got)
+	}
+	c.subscribe("fun", "*", FULL)
+	if sent := ft.sends(); len(sent) != 0 {
+		t.Errorf("resubscribing sent %v", sent)
+	}
+	// Nor when only listening
+	c.subscribe("quiet", "*", LISTEN)
+	if sent := ft.sends(); len(sent) != 0 {
+		t.Errorf("listening sent %v", sent)
+	}
+}
+
+func TestGreetNewSubsDisabled(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	if got := reply(t, c, ft, homeMessage("clyde, subscribe to -c fun")); got != "-c fun sounds awesome! Thanks for the invitation :)" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestPanickingBehavior(t *testing.T) {
+	saved := behaviors
+	defer func() { behaviors = saved }()
+	boom := func(c *Clyde, r zephyr.MessageReaderResult) bool {
+		if util.MessageBody(r) == "boom" {
+			panic("boom")
+		}
+		return false
+	}
+	behaviors = append([]registeredBehavior{{"boom", boom, ""}}, saved...)
+
+	c, ft, _ := newTestClyde(t, "")
+	c.Run()
+	ft.deliver(homeMessage("the cat sat on the mat."))
+	ft.deliver(homeMessage("boom"))
+	ft.deliver(homeMessage("clyde, roll 1d1"))
+
+	// Clyde saved what he'd learned when he recovered, before
+	// shutting down
+	loaded, _, _ := loadTestClyde(t, c.homeDir)
+	if chainSize(loaded) == 0 {
+		t.Error("didn't save the chain after recovering from a panic")
+	}
+	c.Shutdown()
+	if sent := ft.sends(); len(sent) != 1 || sent[0].body != "1" {
+		t.Errorf("sent %v after recovering from a panic", sent)
+	}
+}
+
+func TestSetRandReproducible(t *testing.T) {
+	bodies := []string{
+		"the cat sat on the mat because it was tired. the dog ran away because it was scared.",
+		"clyde, roll 5d20",
+		"who would win in a fight between the cat and the dog?",
+		"clyde, detailed 4d6",
+		"if pirates and ninjas fought, who would win?",
+	}
+	run := func() []string {
+		c, ft, _ := newTestClyde(t, "")
+		c.SetRand(rand.New(rand.NewSource(42)))
+		var replies []string
+		for _, body := range bodies {
+			c.handleMessage(homeMessage(body))
+			for _, s := range ft.sends() {
+				replies = append(replies, s.body)
+			}
+		}
+		return replies
+	}
+	first, second := run(), run()
+	if len(first) != len(bodies)-1 {
+		t.Fatalf("got replies %q, want one per question", first)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("same seed gave different replies:\n%q\n%q", first, second)
+	}
+}
+
+func TestLoadLegacySubs(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(path.Join(dir, subsFile), []byte(`{"foo": 3, "bar": 1, "gone": 0}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _, _ := loadTestClyde(t, dir)
+	want := map[string]subscription{
+		"foo": {Policy: FULL, Instance: "*"},
+		"bar": {Policy: LISTEN, Instance: "*"},
+	}
+	if !reflect.DeepEqual(c.subs, want) {
+		t.Errorf("loaded subs %v, want %v", c.subs, want)
+	}
+}
+
+func TestLoopDetection(t *testing.T) {
+	c, ft, clock := newTestClyde(t, "")
+	bot := message("otherbot", homeClass, homeInstance, "let's sing")
+	for i := 0; i < loopThreshold; i++ {
+		if got := reply(t, c, ft, bot); got != "la la la" {
+			t.Fatalf("got %q", got)
+		}
+		clock.Advance(time.Second)
+	}
+	noReply(t, c, ft, bot)
+	noReply(t, c, ft, message("otherbot", homeClass, homeInstance, "Let's  SING"))
+
+	// Other senders, and people talking to Clyde directly, aren't
+	// in a loop
+	reply(t, c, ft, message("alice", homeClass, homeInstance, "let's sing"))
+	reply(t, c, ft, message("otherbot", homeClass, homeInstance, "clyde, let's sing"))
+
+	clock.Advance(loopWindow)
+	if got := reply(t, c, ft, bot); got != "la la la" {
+		t.Errorf("after the loop window: got %q", got)
+	}
+}
+
+func TestStateMissing(t *testing.T) {
+	c, _, clock := newTestClyde(t, "")
+	if err := c.loadState(); !os.IsNotExist(err) {
+		t.Errorf("loadState with no state file returned %v", err)
+	}
+	if alone := clock.Now().Sub(c.lastInteraction); alone < 0 || alone > time.Second {
+		t.Errorf("with no saved state, Clyde has been alone for %v", alone)
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	c, _, _ := newTestClyde(t, "")
+	if err := os.WriteFile(c.path(stateFile), []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := c.writeFile(stateFile, func(w io.Writer) error {
+		io.WriteString(w, "partial")
+		return errors.New("disk full")
+	})
+	if err == nil {
+		t.Error("writeFile ignored a failed write")
+	}
+	if b, _ := os.ReadFile(c.path(stateFile)); string(b) != "old\n" {
+		t.Errorf("failed write left %q", b)
+	}
+
+	if err := c.saveState(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.loadState(); err != nil {
+		t.Errorf("couldn't load saved state: %v", err)
+	}
+
+	files, err := os.ReadDir(c.homeDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if strings.Contains(f.Name(), ".tmp") {
+			t.Errorf("left temporary file %s behind", f.Name())
+		}
+	}
+}
+
+func TestSendRetry(t *testing.T) {
+	c, ft, clock := newTestClyde(t, "")
+	ft.sendErrs = []error{errors.New("network down"), errors.New("still down")}
+	start := clock.Now()
+	c.send(homeClass, homeInstance, "hello")
+
+	if ft.sendCalls != 3 {
+		t.Errorf("Send called %d times, want 3", ft.sendCalls)
+	}
+	if sent := ft.sends(); len(sent) != 1 || sent[0].body != "hello" {
+		t.Errorf("sent %v, want one hello", sent)
+	}
+	if waited := clock.Now().Sub(start); waited != 3*sendRetryBackoff {
+		t.Errorf("waited %v between attempts, want %v", waited, 3*sendRetryBackoff)
+	}
+	if errs := c.Stats().SendErrors; errs != 0 {
+		t.Errorf("counted %d send errors, want 0", errs)
+	}
+}
+
+func TestSendGivesUp(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	for i := 0; i < sendAttempts+1; i++ {
+		ft.sendErrs = append(ft.sendErrs, errors.New("network down"))
+	}
+	c.send(homeClass, homeInstance, "hello")
+
+	if ft.sendCalls != sendAttempts {
+		t.Errorf("Send called %d times, want %d", ft.sendCalls, sendAttempts)
+	}
+	if errs := c.Stats().SendErrors; errs != 1 {
+		t.Errorf("counted %d send errors, want 1", errs)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	ft.reconnectErrs = []error{errors.New("no tickets"), nil}
+	subs := len(ft.subs)
+	c.Run()
+	ft.hangUp()
+
+	// Clyde handles messages from the new session once he's
+	// reconnected
+	select {
+	case <-ft.reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Clyde didn't reconnect after losing his session")
+	}
+	ft.deliver(homeMessage("clyde, roll 1d1"))
+	c.Shutdown()
+	if ft.reconnects != 2 {
+		t.Errorf("tried to reconnect %d times, want 2", ft.reconnects)
+	}
+	if len(ft.subs) <= subs {
+		t.Error("didn't resubscribe after reconnecting")
+	}
+	if sent := ft.sends(); len(sent) != 1 || sent[0].body != "1" {
+		t.Errorf("sent %v after reconnecting", sent)
+	}
+}
+
+func TestReconnectGivesUp(t *testing.T) {
+	tests := []struct {
+		errs []error
+		reconnects int
+	}{
+		{nil, 1},
+		{[]error{errors.New("a"), errors.New("b"), errors.New("c"), errors.New("d"), errors.New("e")}, reconnectAttempts},
+	}
+	for _, test := range tests {
+		c, ft, _ := newTestClyde(t, "")
+		ft.reconnectErrs = test.errs
+		c.Run()
+		ft.hangUp()
+		select {
+		case <-c.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatal("Clyde didn't stop after losing his session")
+		}
+		c.Shutdown()
+		if ft.reconnects != test.reconnects {
+			t.Errorf("tried to reconnect %d times, want %d", ft.reconnects, test.reconnects)
+		}
+	}
+}
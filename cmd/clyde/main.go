@@ -9,17 +9,28 @@ package main
 
 import (
 	"log"
+	"flag"
 	"time"
 	"path"
+	"path/filepath"
+	"io"
 	"os"
 	"os/user"
 	"os/signal"
 	"syscall"
 	"math/rand"
-	"github.com/sdukhovni/clyde-go"
+	clydelib "github.com/sdukhovni/clyde-go"
 )
 
+// shutdownTimeout is how long to wait for Clyde to shut down cleanly
+// before giving up and exiting anyway.
+const shutdownTimeout = 10*time.Second
+
+var dryRun = flag.Bool("dry-run", false, "read zephyrs from stdin and print replies instead of using zephyr")
+
 func main() {
+	flag.Parse()
+
 	// Seed RNG
 	rand.Seed(time.Now().UnixNano())
 
@@ -31,17 +42,93 @@ func main() {
 	clydeDir := path.Join(curUser.HomeDir, ".clyde")
 
 	// Load Clyde
-	clyde, err := clyde.LoadClyde(clydeDir)
+	var clyde *clydelib.Clyde
+	if *dryRun {
+		// Work on a copy of Clyde's files, so nothing learned or
+		// changed in a dry run touches the real ones
+		var dryRunDir string
+		dryRunDir, err = os.MkdirTemp("", "clyde-dry-run")
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer os.RemoveAll(dryRunDir)
+		err = copyDir(clydeDir, dryRunDir)
+		if err != nil && !os.IsNotExist(err) {
+			log.Fatal(err)
+		}
+		clydeDir = dryRunDir
+
+		t := clydelib.NewDryRunTransport(os.Stdin, os.Stdout, curUser.Username)
+		clyde, err = clydelib.NewClydeWithTransport(clydeDir, t)
+	} else {
+		clyde, err = clydelib.LoadClyde(clydeDir)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer clyde.Shutdown()
+	defer func() {
+		err := clyde.ShutdownWithTimeout(shutdownTimeout)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}()
 
 	// Start Clyde's main goroutine
 	clyde.Run()
 
-	// Keep listening until a SIGINT or SIGTERM.
+	// Reload config on SIGHUP, and keep listening until a SIGINT or
+	// SIGTERM, or until Clyde stops on his own.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	<-c
+	for {
+		select {
+		case <-hup:
+			err := clyde.Reload()
+			if err != nil {
+				log.Printf("Error reloading config: %v", err)
+			}
+		case <-c:
+			return
+		case <-clyde.Done():
+			return
+		}
+	}
+}
+
+// copyDir copies the regular files in the directory src, and in its
+// subdirectories, into the existing directory dst.
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		in, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, in)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	})
 }
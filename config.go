@@ -0,0 +1,378 @@
+// Copyright 2016 Sam Dukhovni <dukhovni@mit.edu>
+//
+// Licensed under the MIT License
+// (https://opensource.org/licenses/MIT)
+//
+//
+// config.go defines Clyde's configuration file, which holds settings
+// that can be changed without recompiling.
+
+package clyde
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+	"github.com/sdukhovni/clyde-go/cat"
+	"github.com/sdukhovni/clyde-go/logger"
+)
+
+// Config holds Clyde's configurable settings, loaded from a JSON file
+// in his home directory. Any setting missing from the file keeps its
+// default value.
+type Config struct {
+	// LogLevel is the minimum level of log messages to write:
+	// "debug", "info", "warn", or "error".
+	LogLevel string
+
+	// Homes lists Clyde's home classes and instances, which he is
+	// always subscribed to. The first is his primary home, where
+	// he redirects replies from classes with the REPLYHOME or
+	// REPLYHOMECLASS policy and does his idle chatter.
+	Homes []Home
+
+	// Personals controls whether Clyde subscribes to personal
+	// zephyrs sent to Principal (his full kerberos principal, with
+	// realm) and replies to them personally. Clyde never learns from
+	// personals.
+	Personals bool
+	Principal string
+
+	// GreetNewSubs controls whether Clyde says hello on a class when
+	// he's invited to subscribe to it.
+	GreetNewSubs bool
+
+	// AllowSenders, if non-empty, lists the only senders (kerberos
+	// principals without realm) whose messages can trigger
+	// behaviors.
+	AllowSenders []string
+	// BlockSenders lists senders whose messages never trigger
+	// behaviors.
+	BlockSenders []string
+	// AdminSenders, if non-empty, lists the only senders who may use
+	// administrative behaviors, such as making Clyde forget
+	// everything. Those behaviors always require authentication.
+	AdminSenders []string
+
+	// LearnOnlyAuthenticated controls whether Clyde only learns from
+	// authenticated messages, and LearnFromSenders, if non-empty,
+	// lists the only senders he learns from. Clyde still responds to
+	// messages he doesn't learn from.
+	LearnOnlyAuthenticated bool
+	LearnFromSenders []string
+	// LearningPaused stops Clyde from learning from anything, as set
+	// by "clyde, stop learning".
+	LearningPaused bool
+
+	// AllowOpCodes lists opcodes for which messages are treated as
+	// ordinary chat; messages with any other non-empty opcode
+	// (pings, auto-replies, etc.) never trigger behaviors.
+	AllowOpCodes []string
+	// LearnFromOpCodes controls whether Clyde still learns from
+	// messages that are ignored because of their opcode.
+	LearnFromOpCodes bool
+
+	// ChatterAfter is how long Clyde must be alone before he starts
+	// idly chatting on his home class, and ChatterInterval is the
+	// average time between idle chatter after that.
+	ChatterAfter Duration
+	ChatterInterval Duration
+	// LonelyAfter is how long Clyde must be alone before he can get
+	// lonely, and LonelyInterval is the average time it takes him to
+	// get lonely after that. LonelyJitter is the most extra time,
+	// chosen randomly when Clyde starts, that he waits on top of
+	// LonelyAfter.
+	LonelyAfter Duration
+	LonelyInterval Duration
+	LonelyJitter Duration
+
+	// ZsigUseChainer controls whether Clyde generates his zsigs from
+	// the zsigs he's seen, rather than always signing as "Clyde".
+	ZsigUseChainer bool
+	// LearnZsigsIntoMainChain controls whether Clyde also learns
+	// from zsigs in the chain he uses for replies.
+	LearnZsigsIntoMainChain bool
+	// ZsigRotateInterval, if nonzero, is how often Clyde generates a
+	// new zsig from the zsigs he's seen and adopts it in place of his
+	// usual one.
+	ZsigRotateInterval Duration
+
+	// PrefixLen is the number of words of context Clyde's chainer
+	// uses. Changing it rebuilds his chain, which loses information
+	// when increasing it.
+	PrefixLen int
+
+	// MinChainWords is the number of words Clyde tries to add to the
+	// start of a reply he generates with his chainer.
+	MinChainWords int
+
+	// AutosaveInterval is how often Clyde saves his chains,
+	// subscriptions, and state while running, so that an unclean
+	// exit loses little. Zero means he only saves when shutting down.
+	AutosaveInterval Duration
+
+	// SenderCooldown is how long a single sender must wait before
+	// triggering a rate-limited behavior (such as chat) again.
+	SenderCooldown Duration
+
+	// CatName is the name of the zephyr cat Clyde plays with.
+	CatName string
+	// CatBoredAfter is how long Clyde must be alone before he
+	// might react to the cat being bored, and CatBoredChance is the
+	// probability that he tries to scoop or play with her when she
+	// is.
+	CatBoredAfter Duration
+	CatBoredChance float64
+
+	// SendDelayFactor is the number of milliseconds Clyde waits per
+	// character of a message before sending it, to simulate typing.
+	SendDelayFactor int
+
+	// MaxResponseRunes is the longest message, in characters, that
+	// Clyde will send; longer messages are truncated. Zero means no
+	// limit.
+	MaxResponseRunes int
+
+	// TimeZone is the IANA name of the time zone Clyde reports the
+	// time in by default; if empty, the system's local time zone
+	// is used.
+	TimeZone string
+	// TimeZoneAliases maps location names people might ask about to
+	// IANA time zone names, in addition to Clyde's built-in list.
+	TimeZoneAliases map[string]string
+}
+
+// defaultConfig returns the configuration Clyde uses when no config
+// file is present.
+func defaultConfig() Config {
+	return Config{
+		LogLevel: "info",
+		Homes: []Home{{homeClass, homeInstance}},
+		SenderCooldown: Duration{time.Minute},
+		AutosaveInterval: Duration{autosaveInterval},
+		MinChainWords: minChainWords,
+		PrefixLen: prefixLen,
+		CatName: cat.CatName,
+		CatBoredAfter: Duration{time.Hour},
+		CatBoredChance: 0.125,
+		SendDelayFactor: sendDelayFactor,
+		MaxResponseRunes: maxResponseRunes,
+		ChatterAfter: Duration{time.Hour},
+		ChatterInterval: Duration{90*time.Minute},
+		LonelyAfter: Duration{2*time.Hour},
+		LonelyInterval: Duration{30*time.Minute},
+		LonelyJitter: Duration{30*time.Minute},
+	}
+}
+
+// Duration is a time.Duration that is represented in JSON as a string
+// such as "1h30m".
+type Duration struct {
+	time.Duration
+}
+
+// MarshalJSON encodes a Duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON decodes a Duration from a string such as "1h30m".
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return err
+	}
+	d.Duration, err = time.ParseDuration(s)
+	return err
+}
+
+// Home is a class and instance that Clyde considers home.
+type Home struct {
+	Class string
+	Instance string
+}
+
+// loadConfig attempts to load Clyde's configuration from a file in
+// JSON format in Clyde's home directory. If the file doesn't exist,
+// the default configuration is returned.
+func (c *Clyde) loadConfig() (Config, error) {
+	config := defaultConfig()
+
+	f, err := os.Open(c.path(configFile))
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	err = dec.Decode(&config)
+	if err != nil {
+		return config, err
+	}
+	if len(config.Homes) == 0 {
+		config.Homes = defaultConfig().Homes
+	}
+	if config.CatName == "" {
+		config.CatName = cat.CatName
+	}
+	if config.PrefixLen < 1 || config.PrefixLen > maxPrefixLen {
+		return config, fmt.Errorf("PrefixLen must be between 1 and %d", maxPrefixLen)
+	}
+	if config.Personals && config.Principal == "" {
+		return config, errors.New("Personals requires a Principal")
+	}
+
+	return config, nil
+}
+
+// saveConfig saves settings changed while Clyde is running to the
+// config file in his home directory. Only the named fields of Clyde's
+// config are written; everything else in the file, including edits
+// the operator hasn't reloaded yet, is kept as it is.
+func (c *Clyde) saveConfig(fields ...string) error {
+	saved := make(map[string]json.RawMessage)
+	b, err := os.ReadFile(c.path(configFile))
+	if err == nil {
+		err = json.Unmarshal(b, &saved)
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	config := reflect.ValueOf(c.config)
+	for _, field := range fields {
+		value, err := json.Marshal(config.FieldByName(field).Interface())
+		if err != nil {
+			return err
+		}
+		// Config keys are matched case-insensitively on load, so
+		// replace the field however the operator spelled it
+		for key := range saved {
+			if strings.EqualFold(key, field) {
+				delete(saved, key)
+			}
+		}
+		saved[field] = value
+	}
+
+	b, err = json.MarshalIndent(saved, "", "\t")
+	if err != nil {
+		return err
+	}
+	return c.writeFile(configFile, func(w io.Writer) error {
+		_, err := w.Write(append(b, '\n'))
+		return err
+	})
+}
+
+// containsFold reports whether list contains s, ignoring case.
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// senderAllowed reports whether messages from the given sender may
+// trigger behaviors.
+func (config Config) senderAllowed(sender string) bool {
+	if containsFold(config.BlockSenders, sender) {
+		return false
+	}
+	return len(config.AllowSenders) == 0 || containsFold(config.AllowSenders, sender)
+}
+
+// learnAllowed reports whether Clyde may learn from a message from
+// the given sender.
+func (config Config) learnAllowed(sender string, authenticated bool) bool {
+	if config.LearnOnlyAuthenticated && !authenticated {
+		return false
+	}
+	return len(config.LearnFromSenders) == 0 || containsFold(config.LearnFromSenders, sender)
+}
+
+// isAdmin reports whether the given sender may use administrative
+// behaviors.
+func (config Config) isAdmin(sender string) bool {
+	return len(config.AdminSenders) == 0 || containsFold(config.AdminSenders, sender)
+}
+
+// Reload re-reads Clyde's config file and blocklist, and applies
+// them without restarting Clyde. Settings that can't be changed while
+// Clyde is running (his homes and personal subscription) keep their
+// old values, with a warning logged.
+func (c *Clyde) Reload() error {
+	if !c.running {
+		return c.reload()
+	}
+
+	// Let the event loop apply the new config, so we don't change
+	// it out from under a behavior
+	errc := make(chan error, 1)
+	select {
+	case c.reloads <- errc:
+		return <-errc
+	case <-c.shutdown:
+		return errors.New("Clyde is shutting down")
+	}
+}
+
+func (c *Clyde) reload() error {
+	config, err := c.loadConfig()
+	if err != nil {
+		return err
+	}
+	level, err := logger.ParseLevel(config.LogLevel)
+	if err != nil {
+		return err
+	}
+
+	if !reflect.DeepEqual(config.Homes, c.config.Homes) {
+		c.log.Warnf("Changing homes requires a restart; keeping %v", c.config.Homes)
+		config.Homes = c.config.Homes
+	}
+	if config.Personals != c.config.Personals || config.Principal != c.config.Principal {
+		c.log.Warnf("Changing personals requires a restart; keeping Personals %v, Principal %q", c.config.Personals, c.config.Principal)
+		config.Personals = c.config.Personals
+		config.Principal = c.config.Principal
+	}
+
+	// Read everything that can fail before changing anything, so a
+	// failed reload leaves Clyde as he was
+	words, err := c.readBlocklist()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if config.PrefixLen != c.config.PrefixLen {
+		err = c.setPrefixLen(config.PrefixLen)
+		if err != nil {
+			c.log.Warnf("Keeping PrefixLen %d: %v", c.config.PrefixLen, err)
+			config.PrefixLen = c.config.PrefixLen
+		}
+	}
+	setBlocklist(c.chain, words)
+	setBlocklist(c.zsigChain, words)
+
+	jitter := config.LonelyAfter != c.config.LonelyAfter || config.LonelyJitter != c.config.LonelyJitter
+	c.config = config
+	c.log.SetLevel(level)
+	if jitter {
+		c.jitterLonelyAfter()
+	}
+	c.log.Infof("Reloaded config")
+	return nil
+}
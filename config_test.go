@@ -0,0 +1,264 @@
+// Copyright 2016 Sam Dukhovni <dukhovni@mit.edu>
+//
+// Licensed under the MIT License
+// (https://opensource.org/licenses/MIT)
+
+package clyde
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"github.com/sdukhovni/clyde-go/markov"
+)
+
+func TestLoadConfig(t *testing.T) {
+	c := &Clyde{homeDir: t.TempDir()}
+	config, err := c.loadConfig()
+	if err != nil || config.LogLevel != "info" {
+		t.Errorf("with no config file, loaded %+v, %v", config, err)
+	}
+
+	err = os.WriteFile(c.path(configFile), []byte(`{"LogLevel": "debug"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err = c.loadConfig()
+	if err != nil || config.LogLevel != "debug" {
+		t.Errorf("loaded %+v, %v", config, err)
+	}
+
+	err = os.WriteFile(c.path(configFile), []byte(`{"LogLevel": `), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.loadConfig(); err == nil {
+		t.Error("loaded a truncated config file")
+	}
+}
+
+func TestSaveConfig(t *testing.T) {
+	c, _, _ := newTestClyde(t, "")
+	// Edits the operator hasn't reloaded yet, with a setting spelled
+	// in a different case
+	edited := `{"learningpaused": false, "SendDelayFactor": 7, "Personals": true, "Principal": "clyde@ATHENA.MIT.EDU", "Homes": [{"Class": "elsewhere", "Instance": "clyde"}]}`
+	if err := os.WriteFile(c.path(configFile), []byte(edited), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c.config.LearningPaused = true
+	if err := c.saveConfig("LearningPaused"); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := c.loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.LearningPaused {
+		t.Error("LearningPaused wasn't saved")
+	}
+	if config.SendDelayFactor != 7 || !config.Personals || config.Principal != "clyde@ATHENA.MIT.EDU" {
+		t.Errorf("saving overwrote edited settings: %+v", config)
+	}
+	if len(config.Homes) != 1 || config.Homes[0].Class != "elsewhere" {
+		t.Errorf("saving overwrote edited Homes: %v", config.Homes)
+	}
+
+	b, err := os.ReadFile(c.path(configFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved map[string]json.RawMessage
+	if err := json.Unmarshal(b, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := saved["learningpaused"]; ok {
+		t.Errorf("saved LearningPaused twice: %s", b)
+	}
+}
+
+func TestSaveConfigBadFile(t *testing.T) {
+	c, _, _ := newTestClyde(t, "")
+	truncated := []byte(`{"LogLevel": `)
+	if err := os.WriteFile(c.path(configFile), truncated, 0644); err != nil {
+		t.Fatal(err)
+	}
+	c.config.LearningPaused = true
+	if err := c.saveConfig("LearningPaused"); err == nil {
+		t.Error("saved over a config file that couldn't be read")
+	}
+	if b, _ := os.ReadFile(c.path(configFile)); string(b) != string(truncated) {
+		t.Errorf("config file changed to %q", b)
+	}
+}
+
+func TestSenderAllowed(t *testing.T) {
+	tests := []struct {
+		config Config
+		sender string
+		want bool
+	}{
+		{Config{}, "alice", true},
+		{Config{BlockSenders: []string{"mallory"}}, "Mallory", false},
+		{Config{BlockSenders: []string{"mallory"}}, "bob", true},
+		{Config{AllowSenders: []string{"Alice"}}, "alice", true},
+		{Config{AllowSenders: []string{"alice"}}, "bob", false},
+		{Config{AllowSenders: []string{"alice"}, BlockSenders: []string{"alice"}}, "alice", false},
+	}
+	for _, test := range tests {
+		if got := test.config.senderAllowed(test.sender); got != test.want {
+			t.Errorf("%+v: senderAllowed(%q) = %v, want %v", test.config, test.sender, got, test.want)
+		}
+	}
+}
+
+func TestHomes(t *testing.T) {
+	c := &Clyde{homeDir: t.TempDir()}
+	err := os.WriteFile(c.path(configFile), []byte(`{"Homes": []}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := c.loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Home{homeClass, homeInstance}); len(config.Homes) != 1 || config.Homes[0] != want {
+		t.Errorf("with no homes configured, loaded %v, want %v", config.Homes, want)
+	}
+
+	err = os.WriteFile(c.path(configFile), []byte(`{"Homes": [{"Class": "home1", "Instance": "clyde"}, {"Class": "home2", "Instance": "chat"}]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.config, err = c.loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Home{"home1", "clyde"}); c.home() != want {
+		t.Errorf("primary home %v, want %v", c.home(), want)
+	}
+	tests := []struct {
+		class, instance string
+		want bool
+	}{
+		{"home1", "clyde", true},
+		{"home2", "chat", true},
+		{"home2", "clyde", false},
+		{homeClass, homeInstance, false},
+	}
+	for _, test := range tests {
+		if got := c.isHome(test.class, test.instance); got != test.want {
+			t.Errorf("isHome(%q, %q) = %v, want %v", test.class, test.instance, got, test.want)
+		}
+	}
+}
+
+func TestDuration(t *testing.T) {
+	var config Config
+	err := json.Unmarshal([]byte(`{"ChatterAfter": "1h30m"}`), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ChatterAfter.Duration != 90*time.Minute {
+		t.Errorf("ChatterAfter is %v, want 1h30m", config.ChatterAfter)
+	}
+
+	b, err := json.Marshal(Duration{10*time.Minute})
+	if err != nil || string(b) != `"10m0s"` {
+		t.Errorf("marshaled 10m as %s, %v", b, err)
+	}
+
+	if err := json.Unmarshal([]byte(`{"LonelyAfter": "soon"}`), &config); err == nil {
+		t.Error("unmarshaled an invalid duration")
+	}
+}
+
+func TestBlockSenders(t *testing.T) {
+	c, ft, _ := newTestClyde(t, `{"BlockSenders": ["mallory"]}`)
+	noReply(t, c, ft, message("Mallory", homeClass, homeInstance, "clyde, roll 2d6"))
+	reply(t, c, ft, message("bob", homeClass, homeInstance, "clyde, roll 2d6"))
+
+	// Clyde still learns from blocked senders
+	if c.chain.(*markov.Chain).Size() == 0 {
+		t.Error("Clyde didn't learn from a blocked sender")
+	}
+}
+
+func TestAllowSenders(t *testing.T) {
+	c, ft, _ := newTestClyde(t, `{"AllowSenders": ["alice"]}`)
+	reply(t, c, ft, message("alice", homeClass, homeInstance, "clyde, roll 2d6"))
+	noReply(t, c, ft, message("bob", homeClass, homeInstance, "clyde, roll 2d6"))
+}
+
+func TestDefaultSenders(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	for _, sender := range []string{"alice", "bob", "mallory"} {
+		reply(t, c, ft, message(sender, homeClass, homeInstance, "clyde, roll 2d6"))
+	}
+}
+
+func TestReload(t *testing.T) {
+	c, _, _ := newTestClyde(t, `{"SendDelayFactor": 10, "ChatterAfter": "1h"}`)
+	config := `{"SendDelayFactor": 0, "ChatterAfter": "3h", "Homes": [{"Class": "elsewhere", "Instance": "clyde"}]}`
+	if err := os.WriteFile(c.path(configFile), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(c.path(blocklistFile), []byte("darn\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.config.SendDelayFactor != 0 {
+		t.Errorf("SendDelayFactor is %d after reload", c.config.SendDelayFactor)
+	}
+	if c.config.ChatterAfter.Duration != 3*time.Hour {
+		t.Errorf("ChatterAfter is %v after reload", c.config.ChatterAfter)
+	}
+	if len(c.config.Homes) != 1 || c.config.Homes[0].Class != homeClass {
+		t.Errorf("Homes changed to %v without a restart", c.config.Homes)
+	}
+	c.learn(homeMessage("oh darn oh darn"))
+	if got := c.chain.Generate("oh", 1, 5); strings.Contains(got, "darn") {
+		t.Errorf("generated reloaded blocked word in %q", got)
+	}
+}
+
+func TestReloadBadConfig(t *testing.T) {
+	c, _, _ := newTestClyde(t, `{"ChatterAfter": "1h"}`)
+	if err := os.WriteFile(c.path(configFile), []byte(`{"LogLevel": "loud", "ChatterAfter": "3h"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Reload(); err == nil {
+		t.Error("reloaded a config with a bad log level")
+	}
+	if c.config.ChatterAfter.Duration != time.Hour {
+		t.Errorf("ChatterAfter is %v after a failed reload", c.config.ChatterAfter)
+	}
+}
+
+func TestReloadBadBlocklist(t *testing.T) {
+	c, _, _ := newTestClyde(t, `{"PrefixLen": 2, "ChatterAfter": "1h"}`)
+	if err := os.WriteFile(c.path(configFile), []byte(`{"PrefixLen": 3, "ChatterAfter": "3h"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A directory can be opened, but not read
+	if err := os.Mkdir(c.path(blocklistFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Reload(); err == nil {
+		t.Error("reloaded with an unreadable blocklist")
+	}
+	if c.config.PrefixLen != 2 {
+		t.Errorf("PrefixLen is %d after a failed reload", c.config.PrefixLen)
+	}
+	if n := c.chain.(*markov.Chain).PrefixLen(); n != 2 {
+		t.Errorf("chain was rebuilt with prefix length %d by a failed reload", n)
+	}
+	if c.config.ChatterAfter.Duration != time.Hour {
+		t.Errorf("ChatterAfter is %v after a failed reload", c.config.ChatterAfter)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2016 Sam Dukhovni <dukhovni@mit.edu>
+//
+// Licensed under the MIT License
+// (https://opensource.org/licenses/MIT)
+//
+//
+// generator.go defines the interface Clyde uses to learn and generate
+// text, so that generators other than the markov chainer can be
+// plugged in.
+
+package clyde
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"time"
+	"github.com/sdukhovni/clyde-go/markov"
+)
+
+// A Generator learns from text and generates new text like it. The
+// markov package's Chain is the default Generator.
+type Generator interface {
+	// Build learns from the text read from r.
+	Build(r io.Reader)
+	// Generate generates text following start, stopping after the
+	// given number of sentences or maxWords words.
+	Generate(start string, sentences, maxWords int) string
+}
+
+// persistentGenerator is a Generator that can be saved to and loaded
+// from a file.
+type persistentGenerator interface {
+	Generator
+	Load(filename string) error
+	Save(filename string) error
+}
+
+// blockingGenerator is a Generator that can be told never to generate
+// certain words.
+type blockingGenerator interface {
+	Generator
+	SetBlocklist(words []string)
+}
+
+// sizedGenerator is a Generator that can report how much it knows.
+type sizedGenerator interface {
+	Generator
+	Size() int
+}
+
+// contextGenerator is a Generator that can stop generating early when
+// a context is done.
+type contextGenerator interface {
+	Generator
+	GenerateCtx(ctx context.Context, start string, sentences, maxWords int) string
+}
+
+// resettableGenerator is a Generator that can forget everything it
+// has learned.
+type resettableGenerator interface {
+	Generator
+	Reset()
+}
+
+// randomGenerator is a Generator whose random number generator can be
+// replaced.
+type randomGenerator interface {
+	Generator
+	SetRand(rng *rand.Rand)
+}
+
+// clockedGenerator is a Generator whose clock can be replaced.
+type clockedGenerator interface {
+	Generator
+	SetNow(now func() time.Time)
+}
+
+// generateCtx generates text with g, stopping early when ctx is done
+// if g supports it.
+func generateCtx(ctx context.Context, g Generator, start string, sentences, maxWords int) string {
+	if cg, ok := g.(contextGenerator); ok {
+		return cg.GenerateCtx(ctx, start, sentences, maxWords)
+	}
+	return g.Generate(start, sentences, maxWords)
+}
+
+// The markov chainer supports everything Clyde knows how to do with a
+// generator.
+var _ persistentGenerator = (*markov.Chain)(nil)
+var _ blockingGenerator = (*markov.Chain)(nil)
+var _ sizedGenerator = (*markov.Chain)(nil)
+var _ contextGenerator = (*markov.Chain)(nil)
+var _ randomGenerator = (*markov.Chain)(nil)
+var _ resettableGenerator = (*markov.Chain)(nil)
+var _ clockedGenerator = (*markov.Chain)(nil)
+
+// SetGenerators replaces the generators Clyde uses for his replies and
+// his zsigs. Generators that can't be saved won't persist across
+// restarts. Generators that support it use Clyde's random number
+// generator and clock.
+func (c *Clyde) SetGenerators(chain, zsigChain Generator) {
+	c.chain = chain
+	c.zsigChain = zsigChain
+	setRand(c.chain, c.rng)
+	setRand(c.zsigChain, c.rng)
+	setNow(c.chain, c.clock.Now)
+	setNow(c.zsigChain, c.clock.Now)
+}
+
+// setBlocklist sets the blocklist of a generator, if it supports one.
+func setBlocklist(g Generator, words []string) {
+	if b, ok := g.(blockingGenerator); ok {
+		b.SetBlocklist(words)
+	}
+}
+
+// setRand sets the random number generator of a generator, if it
+// supports one.
+func setRand(g Generator, rng *rand.Rand) {
+	if r, ok := g.(randomGenerator); ok {
+		r.SetRand(rng)
+	}
+}
+
+// setNow sets the clock of a generator, if it supports one.
+func setNow(g Generator, now func() time.Time) {
+	if cg, ok := g.(clockedGenerator); ok {
+		cg.SetNow(now)
+	}
+}
+
+// saveGenerator saves a generator to the given file, if it supports
+// saving.
+func saveGenerator(g Generator, filename string) error {
+	p, ok := g.(persistentGenerator)
+	if !ok {
+		return nil
+	}
+	return p.Save(filename)
+}
@@ -0,0 +1,58 @@
+// Copyright 2016 Sam Dukhovni <dukhovni@mit.edu>
+//
+// Licensed under the MIT License
+// (https://opensource.org/licenses/MIT)
+
+package clyde
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// stubGenerator is a Generator that records what it learns and what
+// it's asked to generate, and always generates the same thing.
+type stubGenerator struct {
+	learned []string
+	starts []string
+	text string
+}
+
+func (g *stubGenerator) Build(r io.Reader) {
+	b, _ := io.ReadAll(r)
+	g.learned = append(g.learned, string(b))
+}
+
+func (g *stubGenerator) Generate(start string, sentences, maxWords int) string {
+	g.starts = append(g.starts, start)
+	return start + " " + g.text
+}
+
+func TestStubGenerator(t *testing.T) {
+	c, ft, _ := newTestClyde(t, `{"MinChainWords": 1}`)
+	chain := &stubGenerator{text: "are very fluffy."}
+	zsigChain := &stubGenerator{}
+	c.SetGenerators(chain, zsigChain)
+
+	got := reply(t, c, ft, homeMessage("clyde, cats"))
+	if got != "Cats are very fluffy." {
+		t.Errorf("got %q", got)
+	}
+	if !reflect.DeepEqual(chain.starts, []string{"Cats"}) {
+		t.Errorf("generated from %q, want once from \"Cats\"", chain.starts)
+	}
+	if !reflect.DeepEqual(chain.learned, []string{"clyde, cats"}) || len(zsigChain.learned) != 1 {
+		t.Errorf("learned %q and zsigs %q", chain.learned, zsigChain.learned)
+	}
+
+	// Clyde can't save or reset generators that don't support it
+	c.saveAll()
+	if _, err := os.Stat(c.path(chainFile + compressedSuffix)); !os.IsNotExist(err) {
+		t.Errorf("saved a chain that can't be saved: %v", err)
+	}
+	if got := reply(t, c, ft, homeMessage("clyde, forget everything")); got != "I don't know how to forget things." {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2016 Sam Dukhovni <dukhovni@mit.edu>
+//
+// Licensed under the MIT License
+// (https://opensource.org/licenses/MIT)
+//
+// logger provides a minimal leveled logger for clyde-go.
+
+package logger
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// Level is a logging verbosity level; a Logger only writes messages
+// at or above its level.
+type Level int
+
+const (
+	Debug	Level = 0
+	Info	Level = 1
+	Warn	Level = 2
+	Error	Level = 3
+)
+
+// String returns the name of the level, as accepted by ParseLevel.
+func (l Level) String() string {
+	switch l {
+	case Debug:
+		return "debug"
+	case Info:
+		return "info"
+	case Warn:
+		return "warn"
+	case Error:
+		return "error"
+	default:
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+}
+
+// ParseLevel returns the level with the given (case-insensitive)
+// name. An empty name is treated as Info.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return Debug, nil
+	case "", "info":
+		return Info, nil
+	case "warn", "warning":
+		return Warn, nil
+	case "error":
+		return Error, nil
+	default:
+		return Info, fmt.Errorf("unknown log level %q", s)
+	}
+}
+
+// Logger writes log messages at or above a given level to an
+// underlying writer.
+type Logger struct {
+	level Level
+	out *log.Logger
+}
+
+// New returns a Logger writing messages at or above the given level
+// to w.
+func New(w io.Writer, level Level) *Logger {
+	return &Logger{level, log.New(w, "", log.LstdFlags)}
+}
+
+// SetLevel changes the minimum level of messages the Logger writes.
+func (l *Logger) SetLevel(level Level) {
+	l.level = level
+}
+
+func (l *Logger) logf(level Level, format string, v ...interface{}) {
+	if level < l.level {
+		return
+	}
+	l.out.Printf("%s: %s", strings.ToUpper(level.String()), fmt.Sprintf(format, v...))
+}
+
+// Debugf logs a message at the Debug level.
+func (l *Logger) Debugf(format string, v ...interface{}) {
+	l.logf(Debug, format, v...)
+}
+
+// Infof logs a message at the Info level.
+func (l *Logger) Infof(format string, v ...interface{}) {
+	l.logf(Info, format, v...)
+}
+
+// Warnf logs a message at the Warn level.
+func (l *Logger) Warnf(format string, v ...interface{}) {
+	l.logf(Warn, format, v...)
+}
+
+// Errorf logs a message at the Error level.
+func (l *Logger) Errorf(format string, v ...interface{}) {
+	l.logf(Error, format, v...)
+}
@@ -0,0 +1,60 @@
+// Copyright 2016 Sam Dukhovni <dukhovni@mit.edu>
+//
+// Licensed under the MIT License
+// (https://opensource.org/licenses/MIT)
+
+package logger
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLevels(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, Warn)
+	l.Debugf("debug %d", 1)
+	l.Infof("info %d", 2)
+	l.Warnf("warn %d", 3)
+	l.Errorf("error %d", 4)
+
+	out := buf.String()
+	for _, want := range []string{"WARN: warn 3", "ERROR: error 4"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log is missing %q:\n%s", want, out)
+		}
+	}
+	for _, unwanted := range []string{"debug 1", "info 2"} {
+		if strings.Contains(out, unwanted) {
+			t.Errorf("log contains suppressed %q:\n%s", unwanted, out)
+		}
+	}
+
+	buf.Reset()
+	l.SetLevel(Debug)
+	l.Debugf("debug %d", 5)
+	if !strings.Contains(buf.String(), "DEBUG: debug 5") {
+		t.Errorf("debug message suppressed after SetLevel(Debug)")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		s string
+		want Level
+		wantErr bool
+	}{
+		{"", Info, false},
+		{"debug", Debug, false},
+		{"WARNING", Warn, false},
+		{"error", Error, false},
+		{"loud", Info, true},
+	}
+	for _, test := range tests {
+		got, err := ParseLevel(test.s)
+		if got != test.want || (err != nil) != test.wantErr {
+			t.Errorf("ParseLevel(%q) = %v, %v", test.s, got, err)
+		}
+	}
+}
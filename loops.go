@@ -0,0 +1,66 @@
+// Copyright 2016 Sam Dukhovni <dukhovni@mit.edu>
+//
+// Licensed under the MIT License
+// (https://opensource.org/licenses/MIT)
+//
+//
+// loops.go keeps track of Clyde's recent exchanges, so that he can
+// notice when he's stuck in a loop with another bot.
+
+package clyde
+
+import (
+	"hash/fnv"
+	"strings"
+	"time"
+)
+
+const loopHistory = 32 // number of recent exchanges Clyde remembers
+const loopThreshold = 3 // number of near-identical exchanges with the same reply in loopWindow after which Clyde stops replying
+const loopWindow = 5*time.Minute
+
+// exchange is a message from someone that Clyde replied to, along
+// with a hash of his reply.
+type exchange struct {
+	sender string
+	class string
+	hash uint64
+	reply uint64
+	time time.Time
+}
+
+// exchangeLog is a ring buffer of Clyde's most recent exchanges.
+type exchangeLog struct {
+	entries [loopHistory]exchange
+	next int
+}
+
+// bodyHash hashes a message body such that near-identical bodies
+// (differing only in case and spacing) hash the same.
+func bodyHash(body string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(strings.ToLower(strings.Join(strings.Fields(body), " "))))
+	return h.Sum64()
+}
+
+// record adds an exchange to the log, replacing the oldest.
+func (l *exchangeLog) record(e exchange) {
+	l.entries[l.next] = e
+	l.next = (l.next + 1) % loopHistory
+}
+
+// looping reports whether Clyde has already given the same reply to
+// at least loopThreshold exchanges like e within loopWindow before it.
+// Replies that vary, like dice rolls, are never a loop.
+func (l *exchangeLog) looping(e exchange) bool {
+	counts := make(map[uint64]int)
+	for _, prev := range l.entries {
+		if prev.sender == e.sender && prev.class == e.class && prev.hash == e.hash && e.time.Sub(prev.time) < loopWindow {
+			counts[prev.reply]++
+			if counts[prev.reply] >= loopThreshold {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+// Copyright 2016 Sam Dukhovni <dukhovni@mit.edu>
+//
+// Licensed under the MIT License
+// (https://opensource.org/licenses/MIT)
+
+package clyde
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBodyHash(t *testing.T) {
+	if bodyHash("Hi,  all ") != bodyHash("hi, all") {
+		t.Error("near-identical bodies hash differently")
+	}
+	if bodyHash("hi, all") == bodyHash("bye, all") {
+		t.Error("different bodies hash the same")
+	}
+}
+
+func TestExchangeLog(t *testing.T) {
+	var l exchangeLog
+	now := time.Now()
+	ex := exchange{sender: "bot", class: "c", hash: 1, time: now}
+
+	// Replies that vary aren't a loop
+	for i := 0; i < loopThreshold; i++ {
+		ex.reply = uint64(i)
+		l.record(ex)
+	}
+	if l.looping(ex) {
+		t.Error("varying replies counted as a loop")
+	}
+
+	ex.reply = 42
+	for i := 0; i < loopThreshold; i++ {
+		l.record(ex)
+	}
+	if !l.looping(ex) {
+		t.Error("identical replies not counted as a loop")
+	}
+
+	// Old exchanges are forgotten
+	for i := 0; i < loopHistory; i++ {
+		l.record(exchange{sender: "alice", class: "c", hash: 2, time: now})
+	}
+	if l.looping(ex) {
+		t.Error("still looping after the log filled with other exchanges")
+	}
+}
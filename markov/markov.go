@@ -44,13 +44,21 @@ limit. (The word limit is necessary as the chain table may contain cycles.)
 package markov
 
 import (
+	"container/heap"
+	"context"
 	"bufio"
 	"fmt"
 	"io"
 	"math/rand"
 	"strings"
 	"encoding/json"
+	"compress/gzip"
 	"os"
+	"path"
+	"sort"
+	"unicode"
+	"unicode/utf8"
+	"time"
 	"github.com/sdukhovni/clyde-go/stringutil"
 )
 
@@ -60,10 +68,14 @@ import (
 // input/output text.
 type Prefix []string
 
+// startSymbol marks the start of a block of text in a Prefix. Since
+// Shift lowercases words, it can't collide with a learned word.
+const startSymbol = "START"
+
 // NewPrefix creates a new Prefix ending with the "START" symbol.
 func NewPrefix(prefixLen int) (Prefix) {
 	p := make([]string, prefixLen)
-	p[prefixLen-1] = "START"
+	p[prefixLen-1] = startSymbol
 	return p
 }
 
@@ -73,40 +85,281 @@ func (p Prefix) Shift(word string) {
 	p[len(p)-1] = strings.ToLower(word)
 }
 
+// Key returns the chain key for the tail of the Prefix starting at
+// word from: those words joined with spaces.
+func (p Prefix) Key(from int) string {
+	return strings.Join(p[from:], " ")
+}
+
+// tailKey returns p.Key(from) without allocating, by slicing it from
+// full, which must be p.Key(0).
+func (p Prefix) tailKey(full string, from int) string {
+	offset := 0
+	for _, w := range p[:from] {
+		offset += len(w) + 1
+	}
+	if offset > len(full) {
+		return ""
+	}
+	return full[offset:]
+}
+
 // Chain contains a map ("chain") of prefixes to a map of suffixes to
 // frequencies.  A prefix is a string of zero to prefixLen lowercase
-// words joined with spaces.  A suffix is a single word.
+// words joined with spaces.  A suffix is a single word. A parallel map
+// ("lastSeen") holds the Unix time each transition was last added;
+// since it repeats every key of the chain, it roughly doubles the
+// chain's memory use (and the size of its save files). A cache
+// ("sorted") holds each prefix's suffixes in sorted order, for
+// generating text; it's filled in as prefixes are used, and entries
+// are dropped whenever their suffixes change.
 type Chain struct {
 	chain     map[string]map[string]int
+	sorted map[string][]string
+	lastSeen  map[string]map[string]int64
 	prefixLen int
 	stats []int
+	mode Mode
+	blocklist map[string]bool
+	rng *rand.Rand
+	now func() time.Time
 }
 
+// Mode determines what a Chain treats as a single "word".
+type Mode int
+
+const (
+	// Words mode builds chains of whitespace-separated words.
+	Words	Mode = 0
+	// Runes mode builds chains of individual characters (with runs
+	// of whitespace collapsed to a single space), for generating
+	// gibberish that looks like real words.
+	Runes	Mode = 1
+)
+
 // NewChain returns a new Chain with prefixes of prefixLen words.
 func NewChain(prefixLen int) *Chain {
-	return &Chain{make(map[string]map[string]int), prefixLen, make([]int, prefixLen+1)}
+	return NewChainMode(prefixLen, Words)
+}
+
+// NewChainMode returns a new Chain with prefixes of prefixLen tokens,
+// where tokens are words or runes according to mode.
+func NewChainMode(prefixLen int, mode Mode) *Chain {
+	return &Chain{chain: make(map[string]map[string]int), lastSeen: make(map[string]map[string]int64), prefixLen: prefixLen, stats: make([]int, prefixLen+1), mode: mode}
+}
+
+// SetRand sets the random number generator Chain uses to generate
+// text, e.g. a fixed-seed generator for reproducible output. By
+// default, Chain uses the math/rand package's global generator.
+func (c *Chain) SetRand(rng *rand.Rand) {
+	c.rng = rng
+}
+
+// SetNow sets the function Chain uses to tell the time when recording
+// and decaying transitions, e.g. a fake clock for testing. By default,
+// Chain uses time.Now.
+func (c *Chain) SetNow(now func() time.Time) {
+	c.now = now
+}
+
+// timeNow returns the current time according to Chain's clock.
+func (c *Chain) timeNow() time.Time {
+	if c.now == nil {
+		return time.Now()
+	}
+	return c.now()
+}
+
+// intn returns a random int in [0, n) from Chain's generator.
+func (c *Chain) intn(n int) int {
+	if c.rng == nil {
+		return rand.Intn(n)
+	}
+	return c.rng.Intn(n)
+}
+
+// SetBlocklist sets a list of words that Chain will never generate;
+// words match regardless of case or surrounding punctuation.
+func (c *Chain) SetBlocklist(words []string) {
+	c.blocklist = make(map[string]bool)
+	for _, w := range words {
+		c.blocklist[normalizeWord(w)] = true
+	}
+}
+
+// normalizeWord lowercases a word and strips surrounding punctuation,
+// for comparison against the blocklist.
+func normalizeWord(w string) string {
+	return strings.ToLower(strings.TrimFunc(w, unicode.IsPunct))
+}
+
+// blocked reports whether a word is on Chain's blocklist.
+func (c *Chain) blocked(w string) bool {
+	return len(c.blocklist) > 0 && c.blocklist[normalizeWord(w)]
+}
+
+// tokens splits text into the tokens used by Chain's mode.
+func (c *Chain) tokens(text string) []string {
+	if c.mode != Runes {
+		return strings.Fields(text)
+	}
+	var tokens []string
+	for _, r := range strings.Join(strings.Fields(text), " ") {
+		tokens = append(tokens, string(r))
+	}
+	return tokens
+}
+
+// join joins tokens generated by Chain back into text.
+func (c *Chain) join(tokens []string) string {
+	if c.mode != Runes {
+		return strings.Join(tokens, " ")
+	}
+	return strings.Join(tokens, "")
 }
 
 // Add increments the frequency count for a suffix following each
-// distinct tail of a prefix
+// distinct tail of a prefix, and marks those transitions as seen now.
 func (c *Chain) Add(p Prefix, s string) {
+	now := c.timeNow().Unix()
+	full := p.Key(0)
 	for i := 0; i <= c.prefixLen; i++ {
 		if i < c.prefixLen && p[i] == "" {
 			continue
 		}
-		key := strings.Join(p[i:], " ")
+		key := p.tailKey(full, i)
 		if c.chain[key] == nil {
 			c.chain[key] = make(map[string]int)
 		}
+		if c.chain[key][s] == 0 {
+			delete(c.sorted, key)
+		}
 		c.chain[key][s]++
+		if c.lastSeen[key] == nil {
+			c.lastSeen[key] = make(map[string]int64)
+		}
+		c.lastSeen[key][s] = now
+	}
+}
+
+// keyLen returns the number of tokens in a prefix key.
+func (c *Chain) keyLen(key string) int {
+	if key == "" {
+		return 0
+	}
+	if c.mode == Runes {
+		// Every token is a single rune, possibly a space, so
+		// tokens and separators alternate
+		return (utf8.RuneCountInString(key) + 1) / 2
+	}
+	return len(strings.Split(key, " "))
+}
+
+// Rebuild returns a new chain like Chain, but with prefixes of
+// newPrefixLen tokens. Since Chain stores every tail of each prefix,
+// a shorter chain is exactly what it would have learned from the same
+// text; a longer chain only knows the shorter prefixes until it learns
+// more. The new chain shares Chain's blocklist.
+func (c *Chain) Rebuild(newPrefixLen int) *Chain {
+	rebuilt := NewChainMode(newPrefixLen, c.mode)
+	rebuilt.blocklist = c.blocklist
+	rebuilt.rng = c.rng
+	rebuilt.now = c.now
+	for key, suffixes := range c.chain {
+		if c.keyLen(key) > newPrefixLen {
+			continue
+		}
+		rebuilt.chain[key] = make(map[string]int)
+		for s, freq := range suffixes {
+			rebuilt.chain[key][s] = freq
+		}
+		if c.lastSeen[key] != nil {
+			rebuilt.lastSeen[key] = make(map[string]int64)
+			for s, t := range c.lastSeen[key] {
+				rebuilt.lastSeen[key][s] = t
+			}
+		}
+	}
+	return rebuilt
+}
+
+// Reset makes Chain forget everything it has learned, keeping its
+// settings (prefix length, mode, blocklist, and random number
+// generator).
+func (c *Chain) Reset() {
+	c.chain = make(map[string]map[string]int)
+	c.lastSeen = make(map[string]map[string]int64)
+	c.sorted = nil
+	c.stats = make([]int, c.prefixLen+1)
+}
+
+// PrefixLen returns the number of tokens in Chain's prefixes.
+func (c *Chain) PrefixLen() int {
+	return c.prefixLen
+}
+
+// DecayOlderThan multiplies the frequency of every transition that
+// hasn't been added within d by factor, rounding down and forgetting
+// transitions whose frequency drops to zero.
+func (c *Chain) DecayOlderThan(d time.Duration, factor float64) {
+	cutoff := c.timeNow().Add(-d).Unix()
+	for key, suffixes := range c.chain {
+		for s, freq := range suffixes {
+			if c.lastSeen[key][s] >= cutoff {
+				continue
+			}
+			freq = int(float64(freq) * factor)
+			if freq > 0 {
+				suffixes[s] = freq
+				continue
+			}
+			delete(suffixes, s)
+			delete(c.lastSeen[key], s)
+			delete(c.sorted, key)
+		}
+		if len(suffixes) == 0 {
+			delete(c.chain, key)
+			delete(c.lastSeen, key)
+		}
+	}
+}
+
+// touchUnseen marks every transition without a last-seen time as seen
+// now, e.g. after loading a save from before times were recorded.
+func (c *Chain) touchUnseen() {
+	now := c.timeNow().Unix()
+	for key, suffixes := range c.chain {
+		if c.lastSeen[key] == nil {
+			c.lastSeen[key] = make(map[string]int64)
+		}
+		for s := range suffixes {
+			if _, ok := c.lastSeen[key][s]; !ok {
+				c.lastSeen[key][s] = now
+			}
+		}
 	}
 }
 
 // Build reads text from the provided Reader and
 // parses it into prefixes and suffixes that are stored in Chain.
 func (c *Chain) Build(r io.Reader) {
-	br := bufio.NewReader(r)
 	p := NewPrefix(c.prefixLen)
+	if c.mode == Runes {
+		text, _ := io.ReadAll(r)
+		tokens := c.tokens(string(text))
+		for _, s := range tokens {
+			c.Add(p, s)
+			p.Shift(s)
+		}
+		// Mark the end of the text with an empty suffix, so
+		// generation can stop at the end of a "word"
+		if len(tokens) > 0 {
+			c.Add(p, "")
+		}
+		return
+	}
+
+	br := bufio.NewReader(r)
 	for {
 		var s string
 		if _, err := fmt.Fscan(br, &s); err != nil {
@@ -118,33 +371,70 @@ func (c *Chain) Build(r io.Reader) {
 }
 
 // NextWord randomly chooses a word to follow the given prefix, using
-// the weights provided by Chain.
+// the weights provided by Chain. Words on the blocklist are never
+// chosen; if they are the only candidates, NextWord returns an empty
+// string to end generation.
 func (c *Chain) NextWord(p Prefix) string {
+	return c.NextWordNoRepeat(p, "")
+}
+
+// NextWordNoRepeat is like NextWord, but avoids choosing the word
+// last (typically the last word generated) when any other word could
+// follow the prefix. If last is the only candidate, it is returned
+// anyway so that generation can still terminate.
+func (c *Chain) NextWordNoRepeat(p Prefix, last string) string {
 	// Try each tail of the prefix, starting with the longest
+	full := p.Key(0)
 	for i := 0; i <= c.prefixLen; i++ {
-		key := strings.Join(p[i:], " ")
+		key := p.tailKey(full, i)
 		if c.chain[key] == nil {
 			continue
 		}
 
 		c.stats[c.prefixLen-i]++
 
-		// Make a random choice weighted by frequency
+		// Make a random choice weighted by frequency, skipping
+		// blocked words, and the repeated word if there's any
+		// alternative
+		suffixes := c.chain[key]
+		words := c.sortedSuffixes(key)
 		total := 0
-		for _, freq := range c.chain[key] {
-			total += freq
+		repeatTotal := 0
+		blockedTotal := 0
+		for _, w := range words {
+			freq := suffixes[w]
+			switch {
+			case c.blocked(w):
+				blockedTotal += freq
+			case strings.EqualFold(w, last):
+				repeatTotal += freq
+			default:
+				total += freq
+			}
+		}
+		skipRepeats := total > 0
+		if !skipRepeats {
+			total = repeatTotal
 		}
 		if total == 0 {
+			if blockedTotal > 0 {
+				return ""
+			}
 			continue
 		}
-		n := rand.Intn(total)
+		// Walk the candidates in sorted order, so that the choice
+		// depends only on the random number generator
+		n := c.intn(total)
 		var result string
-		for w, freq := range c.chain[key] {
-			n -= freq
-			if n <= 0 {
+		for _, w := range words {
+			if c.blocked(w) || (skipRepeats && strings.EqualFold(w, last)) {
+				continue
+			}
+			if n < suffixes[w] {
 				result = w
 				break
 			}
+			n -= suffixes[w]
 		}
 
 		// If we're making an uninformed choice because we
@@ -162,14 +452,41 @@ func (c *Chain) NextWord(p Prefix) string {
 	return ""
 }
 
+// sortedSuffixes returns the suffixes of a prefix key in sorted
+// order, from Chain's cache if it has them. The returned slice must
+// not be modified.
+func (c *Chain) sortedSuffixes(key string) []string {
+	if words, ok := c.sorted[key]; ok {
+		return words
+	}
+	words := make([]string, 0, len(c.chain[key]))
+	for w := range c.chain[key] {
+		words = append(words, w)
+	}
+	sort.Strings(words)
+	if c.sorted == nil {
+		c.sorted = make(map[string][]string)
+	}
+	c.sorted[key] = words
+	return words
+}
+
 // Generate returns a string of at most maxWords words (in addition to
-// any words in the start string) generated from Chain.  It attempts
-// to generate exactly the requested number of sentences, but may
+// any words in the start string) generated from Chain. It attempts
+// to generate exactly the requested number of sentences, dropping any
+// trailing fragment after the last complete sentence, but may
 // generate fewer if the chain doesn't produce enough
 // sentence-endings, or may generate a single sentence fragment if the
 // chain produces no sentence endings within the word limit.
 func (c *Chain) Generate(start string, sentences, maxWords int) string {
-	words := strings.Fields(start)
+	return c.GenerateCtx(context.Background(), start, sentences, maxWords)
+}
+
+// GenerateCtx is like Generate, but stops early if ctx is
+// done, returning what it has generated so far (trimmed to complete
+// sentences as usual).
+func (c *Chain) GenerateCtx(ctx context.Context, start string, sentences, maxWords int) string {
+	words := c.tokens(start)
 	p := NewPrefix(c.prefixLen)
 	lastWordsStart := len(words) - c.prefixLen
 	if lastWordsStart < 0 {
@@ -179,10 +496,19 @@ func (c *Chain) Generate(start string, sentences, maxWords int) string {
 		p.Shift(w)
 	}
 
+	// If the chain knows nothing about how the start text ends,
+	// kick off generation from a random known prefix instead of
+	// making an uninformed choice
+	if !c.knows(p) {
+		if seed := c.randomPrefix(); seed != nil {
+			p = seed
+		}
+	}
+
 	sentenceCount := 0
 	sentenceEndIndex := 0
-	for i := 0; i < maxWords && sentenceCount < sentences; i++ {
-		next := c.NextWord(p)
+	for i := 0; i < maxWords && sentenceCount < sentences && ctx.Err() == nil; i++ {
+		next := c.nextToken(p)
 		if len(next) == 0 {
 			break
 		}
@@ -196,43 +522,338 @@ func (c *Chain) Generate(start string, sentences, maxWords int) string {
 	if sentenceCount < sentences && sentenceEndIndex > 0 {
 		words = words[:sentenceEndIndex]
 	}
-	return strings.Join(words, " ")
+	return c.join(words)
+}
+
+// generateNAttempts is how many generations GenerateN tries per
+// requested result before giving up on finding distinct ones.
+const generateNAttempts = 3
+
+// GenerateN returns up to n distinct strings generated by
+// Generate from the same start. If the chain is too sparse to
+// produce n distinct strings within a bounded number of attempts, it
+// returns fewer.
+func (c *Chain) GenerateN(start string, n, sentences, maxWords int) []string {
+	var results []string
+	seen := make(map[string]bool)
+	for i := 0; i < n*generateNAttempts && len(results) < n; i++ {
+		result := c.Generate(start, sentences, maxWords)
+		if !seen[result] {
+			seen[result] = true
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// nextToken chooses the next token to follow p while generating text.
+// Repeated letters are normal, so only words avoid repetition.
+func (c *Chain) nextToken(p Prefix) string {
+	if c.mode == Runes {
+		return c.NextWord(p)
+	}
+	last := p[c.prefixLen-1]
+	if last == startSymbol {
+		// Nothing has been generated yet, so nothing can repeat
+		last = ""
+	}
+	return c.NextWordNoRepeat(p, last)
+}
+
+// knows reports whether any non-empty tail of the given prefix has
+// suffixes in Chain.
+func (c *Chain) knows(p Prefix) bool {
+	full := p.Key(0)
+	for i := 0; i < c.prefixLen; i++ {
+		if p[i] == "" {
+			continue
+		}
+		if c.chain[p.tailKey(full, i)] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// randomPrefix returns a full-length prefix chosen uniformly at
+// random from those stored in Chain, or nil if there are none. Keys
+// are sorted before choosing so that the choice depends only on the
+// random number generator.
+func (c *Chain) randomPrefix() Prefix {
+	var keys []string
+	for key := range c.chain {
+		// Skip keys that are too short or that contain a
+		// space token, which can't be split back apart
+		parts := strings.Split(key, " ")
+		if len(parts) != c.prefixLen {
+			continue
+		}
+		complete := true
+		for _, part := range parts {
+			if part == "" {
+				complete = false
+			}
+		}
+		if complete {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	sort.Strings(keys)
+
+	p := make(Prefix, c.prefixLen)
+	copy(p, strings.Split(keys[c.intn(len(keys))], " "))
+	return p
+}
+
+// chainFile is the format in which chains are saved. Version 1 saves
+// have no last-seen times. Older saves are either a bare suffix
+// frequency map (Words mode) or an envelope with only a mode and chain
+// (Runes mode); Load still reads those.
+type chainFile struct {
+	Version int `json:"version"`
+	PrefixLen int `json:"prefixLen"`
+	Mode string `json:"mode"`
+	Chain map[string]map[string]int `json:"chain"`
+	LastSeen map[string]map[string]int64 `json:"lastSeen,omitempty"`
+}
+
+// chainFileVersion is the newest chainFile version Load understands,
+// and the version Save writes.
+const chainFileVersion = 2
+
+const wordModeName = "words"
+const runeModeName = "runes"
+
+// modeName returns the name a mode is saved under.
+func (m Mode) modeName() string {
+	if m == Runes {
+		return runeModeName
+	}
+	return wordModeName
 }
 
-// Load attempts to load a suffix frequency map in JSON format from
-// the given file to use in Chain.
+// isJSONString and isJSONNumber report whether a raw JSON value is a
+// string or a number, as opposed to e.g. an object.
+func isJSONString(raw json.RawMessage) bool {
+	return strings.HasPrefix(string(raw), "\"")
+}
+
+func isJSONNumber(raw json.RawMessage) bool {
+	var n json.Number
+	return json.Unmarshal(raw, &n) == nil
+}
+
+// Load attempts to load a saved chain in JSON format from the given
+// file to use in Chain, decompressing it first if it is gzipped.
+// Saves from older versions are migrated transparently. It returns an
+// error if the file was saved from a chain with a different mode or
+// prefix length, or by a newer version that Load doesn't understand.
+// Transitions from saves without last-seen times count as seen when
+// they're loaded.
+//
+// If the file was saved from a chain with a different prefix length,
+// Load rebuilds it to the chain's prefix length (see Rebuild) and
+// returns a *PrefixLenError; the chain is still usable in that case.
 func (c *Chain) Load(filename string) error {
+	err := c.load(filename)
+	c.sorted = nil
+	if _, ok := err.(*PrefixLenError); err == nil || ok {
+		c.touchUnseen()
+	}
+	return err
+}
+
+// PrefixLenError reports that a saved chain had a different prefix
+// length than the chain it was loaded into.
+type PrefixLenError struct {
+	Filename string
+	Saved int
+	Loaded int
+}
+
+func (e *PrefixLenError) Error() string {
+	return fmt.Sprintf("%s was saved from a chain with prefix length %d, rebuilt with prefix length %d", e.Filename, e.Saved, e.Loaded)
+}
+
+func (c *Chain) load(filename string) error {
 	f, err := os.Open(filename)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	dec := json.NewDecoder(f)
-	err = dec.Decode(&(c.chain))
+	var r io.Reader = bufio.NewReader(f)
+	magic, _ := r.(*bufio.Reader).Peek(len(gzipMagic))
+	if string(magic) == gzipMagic {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var raw map[string]json.RawMessage
+	dec := json.NewDecoder(r)
+	err = dec.Decode(&raw)
 	if err != nil {
 		return err
 	}
 
+	// A Words-mode chain saved as a bare map may well have a
+	// "version" or "mode" prefix, but its value will be an object
+	// rather than a number or string
+	if version, ok := raw["version"]; ok && isJSONNumber(version) {
+		var saved chainFile
+		err = json.Unmarshal(version, &saved.Version)
+		if err != nil {
+			return err
+		}
+		if saved.Version > chainFileVersion {
+			return fmt.Errorf("%s was saved in chain format version %d, but only versions up to %d are supported", filename, saved.Version, chainFileVersion)
+		}
+		for _, field := range []string{"prefixLen", "mode", "chain"} {
+			if _, ok := raw[field]; !ok {
+				return fmt.Errorf("%s is missing %q", filename, field)
+			}
+		}
+		err = json.Unmarshal(raw["prefixLen"], &saved.PrefixLen)
+		if err == nil {
+			err = json.Unmarshal(raw["mode"], &saved.Mode)
+		}
+		if err != nil {
+			return err
+		}
+		if saved.Mode != c.mode.modeName() {
+			return fmt.Errorf("%s was saved from a chain with a different mode", filename)
+		}
+		loaded := c
+		if saved.PrefixLen != c.prefixLen {
+			loaded = NewChainMode(saved.PrefixLen, c.mode)
+		}
+		if lastSeen, ok := raw["lastSeen"]; ok {
+			err = json.Unmarshal(lastSeen, &(loaded.lastSeen))
+			if err != nil {
+				return err
+			}
+		}
+		err = json.Unmarshal(raw["chain"], &(loaded.chain))
+		if err != nil || loaded == c {
+			return err
+		}
+		rebuilt := loaded.Rebuild(c.prefixLen)
+		c.chain = rebuilt.chain
+		c.lastSeen = rebuilt.lastSeen
+		return &PrefixLenError{filename, saved.PrefixLen, c.prefixLen}
+	}
+
+	mode, isRunes := raw["mode"]
+	isRunes = isRunes && isJSONString(mode)
+	if isRunes != (c.mode == Runes) {
+		return fmt.Errorf("%s was saved from a chain with a different mode", filename)
+	}
+
+	if c.mode == Runes {
+		return json.Unmarshal(raw["chain"], &(c.chain))
+	}
+	for key, suffixes := range raw {
+		var m map[string]int
+		err = json.Unmarshal(suffixes, &m)
+		if err != nil {
+			return err
+		}
+		c.chain[key] = m
+	}
+
 	return nil
 }
 
-// Save saves a chain's suffix frequency map to the given file in JSON
-// format
+// gzipMagic is the header that begins every gzip stream.
+const gzipMagic = "\x1f\x8b"
+
+// Save saves a chain's suffix frequency map, along with its mode and
+// prefix length, to the given file in JSON format, gzip-compressed if
+// the filename ends in ".gz". The chain is written to a temporary file
+// which is then renamed into place, so a crash mid-write can't corrupt
+// an existing save file.
 func (c *Chain) Save(filename string) error {
-	f, err := os.Create(filename)
+	f, err := os.CreateTemp(path.Dir(filename), path.Base(filename)+".tmp")
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	defer os.Remove(f.Name()) // no-op once renamed
+
+	data := chainFile{
+		Version: chainFileVersion,
+		PrefixLen: c.prefixLen,
+		Mode: c.mode.modeName(),
+		Chain: c.chain,
+		LastSeen: c.lastSeen,
+	}
 
-	enc := json.NewEncoder(f)
-	err = enc.Encode(c.chain)
+	var w io.Writer = f
+	var gz *gzip.Writer
+	if strings.HasSuffix(filename, ".gz") {
+		gz = gzip.NewWriter(f)
+		w = gz
+	}
+
+	enc := json.NewEncoder(w)
+	err = enc.Encode(data)
+	if err == nil && gz != nil {
+		err = gz.Close()
+	}
 	if err != nil {
+		f.Close()
 		return err
 	}
 
-	return nil
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), filename)
+}
+
+// dotQuote quotes a string for use as a Graphviz DOT identifier.
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, "\\", "\\\\")
+	s = strings.ReplaceAll(s, "\"", "\\\"")
+	s = strings.ReplaceAll(s, "\n", "\\n")
+	return "\"" + s + "\""
+}
+
+// ExportDOT writes the chain to w as a Graphviz DOT graph, with an
+// edge labeled with its frequency from each prefix to each of its
+// suffixes. Edges with a frequency below minFreq are left out.
+func (c *Chain) ExportDOT(w io.Writer, minFreq int) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph chain {")
+
+	var prefixes []string
+	for prefix := range c.chain {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		var suffixes []string
+		for suffix, freq := range c.chain[prefix] {
+			if freq >= minFreq {
+				suffixes = append(suffixes, suffix)
+			}
+		}
+		sort.Strings(suffixes)
+		for _, suffix := range suffixes {
+			fmt.Fprintf(bw, "\t%s -> %s [label=%d];\n", dotQuote(prefix), dotQuote(suffix), c.chain[prefix][suffix])
+		}
+	}
+
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
 }
 
 // Size returns the number of prefixes stored in the chain.
@@ -240,11 +861,107 @@ func (c *Chain) Size() int {
 	return len(c.chain)
 }
 
-// Stats returns a histogram of what prefix lengths are being used to
-// generate words. The nth entry in the returned array holds the
-// number of words generated using length-n prefixes.
-func (c *Chain) Stats() []int {
-	retval := make([]int, len(c.stats))
-	copy(retval, c.stats)
-	return retval
+// Transition is a prefix->suffix transition stored in a Chain, along
+// with its frequency.
+type Transition struct {
+	Prefix string
+	Suffix string
+	Freq int
+}
+
+// ChainStats summarizes the contents and usage of a Chain.
+type ChainStats struct {
+	// Prefixes is the number of distinct prefixes stored.
+	Prefixes int
+	// Suffixes is the total number of distinct prefix->suffix
+	// transitions stored.
+	Suffixes int
+	// Frequency is the sum of the frequencies of all transitions.
+	Frequency int
+	// Top holds the most frequent transitions (excluding those
+	// from the empty prefix), most frequent first.
+	Top []Transition
+	// Usage is a histogram of what prefix lengths have been used to
+	// generate words. The nth entry holds the number of words
+	// generated using length-n prefixes.
+	Usage []int
+}
+
+// statsTopN is the number of top transitions reported by Stats.
+const statsTopN = 10
+
+// outranks reports whether transition a belongs ahead of b in
+// ChainStats.Top: more frequent first, then alphabetically.
+func outranks(a, b Transition) bool {
+	if a.Freq != b.Freq {
+		return a.Freq > b.Freq
+	}
+	if a.Prefix != b.Prefix {
+		return a.Prefix < b.Prefix
+	}
+	return a.Suffix < b.Suffix
+}
+
+// transitionHeap is a min-heap of transitions, with the lowest-ranked
+// on top, so Stats can keep the top transitions without collecting
+// and sorting all of them.
+type transitionHeap []Transition
+
+func (h transitionHeap) Len() int           { return len(h) }
+func (h transitionHeap) Less(i, j int) bool { return outranks(h[j], h[i]) }
+func (h transitionHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *transitionHeap) Push(x interface{}) {
+	*h = append(*h, x.(Transition))
+}
+
+func (h *transitionHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// Stats returns a summary of the chain's contents and usage. It walks
+// the whole chain, so callers that only need the usage histogram
+// should call Usage instead. Like Chain's other methods, it must not
+// be called concurrently with methods that modify the chain.
+func (c *Chain) Stats() ChainStats {
+	var stats ChainStats
+	stats.Prefixes = len(c.chain)
+	top := make(transitionHeap, 0, statsTopN+1)
+	for prefix, suffixes := range c.chain {
+		stats.Suffixes += len(suffixes)
+		for suffix, freq := range suffixes {
+			stats.Frequency += freq
+			if prefix == "" {
+				continue
+			}
+			t := Transition{prefix, suffix, freq}
+			if len(top) == statsTopN && !outranks(t, top[0]) {
+				continue
+			}
+			heap.Push(&top, t)
+			if len(top) > statsTopN {
+				heap.Pop(&top)
+			}
+		}
+	}
+
+	stats.Top = make([]Transition, len(top))
+	for i := len(top) - 1; i >= 0; i-- {
+		stats.Top[i] = heap.Pop(&top).(Transition)
+	}
+
+	stats.Usage = c.Usage()
+	return stats
+}
+
+// Usage returns a histogram of what prefix lengths have been used to
+// generate words, as in ChainStats.Usage. Unlike Stats, it doesn't
+// walk the chain.
+func (c *Chain) Usage() []int {
+	usage := make([]int, len(c.stats))
+	copy(usage, c.stats)
+	return usage
 }
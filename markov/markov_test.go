@@ -0,0 +1,699 @@
+// Copyright 2016 Sam Dukhovni <dukhovni@mit.edu>
+//
+// Licensed under the MIT License
+// (https://opensource.org/licenses/MIT)
+
+package markov
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"github.com/sdukhovni/clyde-go/stringutil"
+)
+
+// countSentences returns the number of words in s that end a
+// sentence.
+func countSentences(s string) int {
+	n := 0
+	for _, w := range strings.Fields(s) {
+		if stringutil.IsEndOfSentence(w) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestGenerateStopsAtSentences(t *testing.T) {
+	c := newTestChain(2, "the cat sat. the dog ran! the cat ran? the dog sat.")
+	for sentences := 1; sentences <= 4; sentences++ {
+		for i := 0; i < 20; i++ {
+			got := c.Generate("", sentences, 100)
+			if n := countSentences(got); n != sentences {
+				t.Fatalf("Generate(%d sentences) = %q, with %d sentences", sentences, got, n)
+			}
+			if !stringutil.IsEndOfSentence(got) {
+				t.Fatalf("Generate(%d sentences) = %q, which ends mid-sentence", sentences, got)
+			}
+		}
+	}
+}
+
+func TestGenerateTrimsFragment(t *testing.T) {
+	// Only one sentence fits in the word limit, followed by a
+	// fragment
+	c := newTestChain(2, "one sentence here. and then")
+	if got := c.Generate("", 3, 5); got != "one sentence here." {
+		t.Errorf("got %q, want only the complete sentence", got)
+	}
+
+	// With no sentence endings at all, the fragment is all there is
+	c = newTestChain(2, "no endings anywhere")
+	if got := c.Generate("", 1, 3); got != "no endings anywhere" {
+		t.Errorf("got %q, want the whole fragment", got)
+	}
+}
+
+func TestNextWordNoRepeat(t *testing.T) {
+	c := newTestChain(1, "la la la la la la la la end.")
+	p := Prefix{"la"}
+	for i := 0; i < 20; i++ {
+		if got := c.NextWordNoRepeat(p, "la"); got != "end." {
+			t.Fatalf("NextWordNoRepeat repeated %q", got)
+		}
+	}
+
+	got := c.Generate("", 1, 100)
+	words := strings.Fields(got)
+	for i := 1; i < len(words); i++ {
+		if strings.EqualFold(words[i], words[i-1]) {
+			t.Errorf("Generate repeated %q in %q", words[i], got)
+		}
+	}
+	if !strings.HasSuffix(got, "end.") {
+		t.Errorf("Generate = %q, want it to end the sentence", got)
+	}
+}
+
+func TestNextWordOnlyRepeat(t *testing.T) {
+	// The only way to continue is to repeat, so generation must
+	// still be able to proceed and stop at the word limit
+	c := newTestChain(1, "la la la")
+	if got := c.NextWordNoRepeat(Prefix{"la"}, "la"); got != "la" {
+		t.Errorf("NextWordNoRepeat = %q, want the repeated word", got)
+	}
+	if got := c.Generate("", 1, 4); got != "la la la la" {
+		t.Errorf("Generate = %q", got)
+	}
+}
+
+func TestSaveError(t *testing.T) {
+	c := newTestChain(2, "some text.")
+	err := c.Save(path.Join(t.TempDir(), "missing", "chain.json"))
+	if err == nil {
+		t.Error("Save into a missing directory succeeded")
+	}
+}
+
+func TestSaveRename(t *testing.T) {
+	dir := t.TempDir()
+	filename := path.Join(dir, "chain.json")
+	err := os.WriteFile(filename, []byte("old save"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestChain(2, "the cat sat on the mat.")
+	err = c.Save(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the save itself is left behind, with no temporary file
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "chain.json" {
+		t.Errorf("directory holds %v, want only chain.json", entries)
+	}
+
+	loaded := NewChain(2)
+	err = loaded.Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.chain, c.chain) {
+		t.Errorf("loaded chain %v, want %v", loaded.chain, c.chain)
+	}
+}
+
+func TestGenerateUnknownStart(t *testing.T) {
+	c := newTestChain(2, "the cat sat on the mat. the dog sat on the log.")
+	start := "zebras are"
+	got := c.Generate(start, 1, 20)
+	if !strings.HasPrefix(got, start) || len(strings.Fields(got)) <= len(strings.Fields(start)) {
+		t.Errorf("Generate(%q) = %q, want more words after the start", start, got)
+	}
+}
+
+func TestLoadWrongMode(t *testing.T) {
+	filename := path.Join(t.TempDir(), "chain.json")
+	runes := NewChainMode(3, Runes)
+	runes.Build(strings.NewReader("banana"))
+	err := runes.Save(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewChain(3).Load(filename); err == nil {
+		t.Error("loaded a rune chain into a word chain")
+	}
+	if err := NewChainMode(3, Runes).Load(filename); err != nil {
+		t.Errorf("couldn't load a rune chain: %v", err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	// Prefixes: "START" -> a; "" -> a (2), b (2); "a" -> b (2);
+	// "b" -> a
+	c := newTestChain(1, "a b a b")
+	stats := c.Stats()
+	if stats.Prefixes != 4 {
+		t.Errorf("%d prefixes, want 4", stats.Prefixes)
+	}
+	if stats.Suffixes != 5 {
+		t.Errorf("%d suffixes, want 5", stats.Suffixes)
+	}
+	if stats.Frequency != 8 {
+		t.Errorf("total frequency %d, want 8", stats.Frequency)
+	}
+	wantTop := []Transition{{"a", "b", 2}, {"START", "a", 1}, {"b", "a", 1}}
+	if !reflect.DeepEqual(stats.Top, wantTop) {
+		t.Errorf("top transitions %v, want %v", stats.Top, wantTop)
+	}
+}
+
+func TestStatsTop(t *testing.T) {
+	// Transitions from "w0" through "w14", each seen one more time
+	// than the last
+	c := NewChain(1)
+	for i := 0; i < 15; i++ {
+		for j := 0; j <= i; j++ {
+			c.Add(Prefix{fmt.Sprintf("w%d", i)}, "x")
+		}
+	}
+	var wantTop []Transition
+	for i := 14; i >= 15-statsTopN; i-- {
+		wantTop = append(wantTop, Transition{fmt.Sprintf("w%d", i), "x", i + 1})
+	}
+	if top := c.Stats().Top; !reflect.DeepEqual(top, wantTop) {
+		t.Errorf("top transitions %v, want %v", top, wantTop)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	c := newTestChain(2, "the cat sat on the mat.")
+	c.Generate("", 1, 20)
+	usage := c.Usage()
+	total := 0
+	for _, count := range usage {
+		total += count
+	}
+	if total == 0 {
+		t.Error("generating words wasn't counted")
+	}
+	if stats := c.Stats(); !reflect.DeepEqual(stats.Usage, usage) {
+		t.Errorf("Stats usage %v, want %v", stats.Usage, usage)
+	}
+	usage[0]++
+	if reflect.DeepEqual(c.Usage(), usage) {
+		t.Error("modifying the returned usage changed the chain")
+	}
+}
+
+func TestSaveGzip(t *testing.T) {
+	filename := path.Join(t.TempDir(), "chain.json.gz")
+	c := newTestChain(2, "the cat sat on the mat.")
+	err := c.Save(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(b), gzipMagic) {
+		t.Error("Save didn't compress a .gz file")
+	}
+
+	loaded := NewChain(2)
+	err = loaded.Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.chain, c.chain) {
+		t.Errorf("loaded chain %v, want %v", loaded.chain, c.chain)
+	}
+}
+
+func TestBlocklistStops(t *testing.T) {
+	// The only way to continue after "say" is blocked
+	c := newTestChain(1, "say darn")
+	c.SetBlocklist([]string{"DARN"})
+	if got := c.NextWord(Prefix{"say"}); got != "" {
+		t.Errorf("NextWord = %q, want generation to stop", got)
+	}
+	if got := c.Generate("say", 1, 10); got != "say" {
+		t.Errorf("Generate = %q, want only the start", got)
+	}
+}
+
+func TestBlocklistAlternative(t *testing.T) {
+	c := newTestChain(1, "say darn say darn say darn say heck")
+	c.SetBlocklist([]string{"darn"})
+	for i := 0; i < 20; i++ {
+		if got := c.NextWord(Prefix{"say"}); got != "heck" {
+			t.Fatalf("NextWord = %q, want the unblocked alternative", got)
+		}
+	}
+
+	// Blocked words match regardless of surrounding punctuation
+	c = newTestChain(1, "say darn! say heck!")
+	c.SetBlocklist([]string{"darn"})
+	for i := 0; i < 20; i++ {
+		if got := c.NextWord(Prefix{"say"}); got != "heck!" {
+			t.Fatalf("NextWord = %q, want the unblocked alternative", got)
+		}
+	}
+}
+
+func TestGenerateNSparse(t *testing.T) {
+	c := newTestChain(2, "only one thing to say.")
+	got := c.GenerateN("", 5, 1, 20)
+	if !reflect.DeepEqual(got, []string{"only one thing to say."}) {
+		t.Errorf("GenerateN(5) = %q, want the only sentence once", got)
+	}
+}
+
+// writeJSON writes v to a file in a temporary directory as JSON and
+// returns the file's name.
+func writeJSON(t *testing.T, v interface{}) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := path.Join(t.TempDir(), "chain.json")
+	if err := os.WriteFile(filename, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestLoadLegacy(t *testing.T) {
+	// A bare map, which may have prefixes that look like envelope
+	// fields
+	saved := newTestChain(1, "the version is the mode.")
+	if _, ok := saved.chain["version"]; !ok {
+		t.Fatalf("test chain %v has no \"version\" prefix", saved.chain)
+	}
+	c := NewChain(1)
+	if err := c.Load(writeJSON(t, saved.chain)); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c.chain, saved.chain) {
+		t.Errorf("loaded chain %v, want %v", c.chain, saved.chain)
+	}
+}
+
+func TestLoadV1(t *testing.T) {
+	saved := newTestChain(2, "the cat sat on the mat.")
+	envelope := map[string]interface{}{"version": 1, "prefixLen": 2, "mode": "words", "chain": saved.chain}
+	c := NewChain(2)
+	if err := c.Load(writeJSON(t, envelope)); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c.chain, saved.chain) {
+		t.Errorf("loaded chain %v, want %v", c.chain, saved.chain)
+	}
+}
+
+func TestLoadFutureVersion(t *testing.T) {
+	envelope := map[string]interface{}{"version": chainFileVersion + 1, "prefixLen": 2, "mode": "words", "chain": map[string]interface{}{}}
+	err := NewChain(2).Load(writeJSON(t, envelope))
+	if err == nil || !strings.Contains(err.Error(), "version") {
+		t.Errorf("loading a future version returned %v", err)
+	}
+}
+
+func TestLoadIncomplete(t *testing.T) {
+	envelope := map[string]interface{}{"version": 1, "mode": "words"}
+	if err := NewChain(2).Load(writeJSON(t, envelope)); err == nil {
+		t.Error("loaded an envelope with no chain")
+	}
+}
+
+func TestExportDOT(t *testing.T) {
+	c := NewChain(1)
+	c.chain = map[string]map[string]int{
+		"a": {"b": 2, "say \"hi\"": 1},
+		"b\\c": {"a": 3, "line\nbreak": 2},
+	}
+	var b strings.Builder
+	if err := c.ExportDOT(&b, 2); err != nil {
+		t.Fatal(err)
+	}
+	want := "digraph chain {\n" +
+		"\t\"a\" -> \"b\" [label=2];\n" +
+		"\t\"b\\\\c\" -> \"a\" [label=3];\n" +
+		"\t\"b\\\\c\" -> \"line\\nbreak\" [label=2];\n" +
+		"}\n"
+	if got := b.String(); got != want {
+		t.Errorf("ExportDOT wrote\n%s\nwant\n%s", got, want)
+	}
+
+	b.Reset()
+	if err := c.ExportDOT(&b, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "\t\"a\" -> \"say \\\"hi\\\"\" [label=1];\n") {
+		t.Errorf("ExportDOT with no minimum left out an edge:\n%s", b.String())
+	}
+}
+
+// failingWriter is an io.Writer that always fails.
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, os.ErrClosed
+}
+
+func TestExportDOTError(t *testing.T) {
+	c := newTestChain(1, "the cat sat.")
+	if err := c.ExportDOT(failingWriter{}, 0); err == nil {
+		t.Error("ExportDOT didn't report a write error")
+	}
+}
+
+func TestRebuild(t *testing.T) {
+	text := "the cat sat on the mat. the dog sat on the cat."
+	c := newTestChain(3, text)
+	shorter := c.Rebuild(1)
+	if shorter.PrefixLen() != 1 {
+		t.Errorf("rebuilt chain has prefix length %d", shorter.PrefixLen())
+	}
+	if !reflect.DeepEqual(shorter.chain, newTestChain(1, text).chain) {
+		t.Errorf("shorter chain %v", shorter.chain)
+	}
+
+	longer := newTestChain(1, text).Rebuild(3)
+	if longer.PrefixLen() != 3 {
+		t.Errorf("rebuilt chain has prefix length %d", longer.PrefixLen())
+	}
+	if !reflect.DeepEqual(longer.chain, newTestChain(1, text).chain) {
+		t.Errorf("longer chain %v", longer.chain)
+	}
+	// The rebuilt chain learns with its new prefix length
+	longer.Build(strings.NewReader(text))
+	if _, ok := longer.chain["the cat sat"]; !ok {
+		t.Errorf("longer chain learned %v", longer.chain)
+	}
+}
+
+func TestLoadOtherPrefixLen(t *testing.T) {
+	filename := path.Join(t.TempDir(), "chain.json")
+	if err := newTestChain(2, "the cat sat on the mat.").Save(filename); err != nil {
+		t.Fatal(err)
+	}
+	c := NewChain(1)
+	err := c.Load(filename)
+	if e, ok := err.(*PrefixLenError); !ok || e.Saved != 2 || e.Loaded != 1 {
+		t.Fatalf("loading a chain with another prefix length returned %v", err)
+	}
+	if !reflect.DeepEqual(c.chain, newTestChain(1, "the cat sat on the mat.").chain) {
+		t.Errorf("rebuilt chain %v", c.chain)
+	}
+}
+
+func TestPrefixTailKey(t *testing.T) {
+	for _, p := range []Prefix{
+		NewPrefix(1),
+		NewPrefix(3),
+		{"the", "cat", "sat"},
+		{"", "cat", "sat."},
+		{"", "", "START"},
+	} {
+		full := p.Key(0)
+		for i := 0; i <= len(p); i++ {
+			if got, want := p.tailKey(full, i), strings.Join(p[i:], " "); got != want {
+				t.Errorf("%q.tailKey(%d) = %q, want %q", []string(p), i, got, want)
+			}
+		}
+	}
+}
+
+// generateText is the text TestGenerateUnchanged learns from.
+const generateText = "the cat sat on the mat. the dog sat on the log. the cat ate the dog's dinner! did the dog mind? the dog minded very much."
+
+func BenchmarkGenerate(b *testing.B) {
+	c := newTestChain(3, strings.Repeat(generateText+" ", 10))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Generate("", 5, 200)
+	}
+}
+
+// BenchmarkNextWord measures choosing a single word after a prefix
+// with many suffixes, once the chain's sorted suffix lists are cached.
+func BenchmarkNextWord(b *testing.B) {
+	c := newTestChain(1, strings.Repeat(generateText+" ", 10))
+	p := Prefix{""}
+	c.NextWord(p)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.NextWordNoRepeat(p, "the")
+	}
+}
+
+func BenchmarkPrefixKeys(b *testing.B) {
+	p := Prefix{"the", "cat", "sat"}
+	b.Run("Join", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for j := 0; j <= len(p); j++ {
+				_ = strings.Join(p[j:], " ")
+			}
+		}
+	})
+	b.Run("tailKey", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			full := p.Key(0)
+			for j := 0; j <= len(p); j++ {
+				_ = p.tailKey(full, j)
+			}
+		}
+	})
+}
+
+func TestGenerateCtx(t *testing.T) {
+	// With no sentence ends, generation only stops at maxWords
+	text := strings.Repeat("round and round we go ", 20)
+	c := newTestChain(2, text)
+	got := c.GenerateCtx(context.Background(), "", 1, 50)
+	if n := len(strings.Fields(got)); n != 50 {
+		t.Errorf("generated %d words, want 50: %q", n, got)
+	}
+	if want := newTestChain(2, text).Generate("", 1, 50); got != want {
+		t.Errorf("GenerateCtx = %q, Generate = %q", got, want)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	got = c.GenerateCtx(ctx, "round and", 1, 10000000)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %v to give up", elapsed)
+	}
+	if got != "round and" {
+		t.Errorf("generated %q after being canceled", got)
+	}
+}
+
+func TestStartWord(t *testing.T) {
+	// A learned word "start" isn't mistaken for the start of text
+	c := newTestChain(1, "start start start start over.")
+	for i := 0; i < 20; i++ {
+		if got := c.nextToken(Prefix{"start"}); got != "over." {
+			t.Fatalf("nextToken = %q, want \"over.\"", got)
+		}
+	}
+}
+
+// newTestChain returns a chain with the given prefix length that has
+// learned text, generating with a fixed-seed random number generator.
+func newTestChain(prefixLen int, text string) *Chain {
+	c := NewChain(prefixLen)
+	c.SetRand(rand.New(rand.NewSource(1)))
+	c.Build(strings.NewReader(text))
+	return c
+}
+
+func TestRunesMode(t *testing.T) {
+	words := "banana bandana cabana savanna"
+	c := NewChainMode(3, Runes)
+	c.SetRand(rand.New(rand.NewSource(1)))
+	c.Build(strings.NewReader(words))
+
+	for i := 0; i < 20; i++ {
+		got := c.Generate("", 1, 12)
+		if got == "" {
+			t.Fatal("generated nothing")
+		}
+		for _, r := range got {
+			if !strings.ContainsRune(words, r) {
+				t.Fatalf("generated %q, with %q not in the training text", got, r)
+			}
+		}
+	}
+	if got := c.Generate("ban", 1, 3); !strings.HasPrefix(got, "ban") || strings.Contains(got, "ban ") {
+		t.Errorf("Generate(\"ban\") = %q, want runes joined without spaces", got)
+	}
+}
+
+func TestSetRand(t *testing.T) {
+	text := "the cat sat on the mat. the dog sat on the log. a cat ate the dog food. the mat ate a log."
+	generate := func(seed int64) []string {
+		c := NewChain(2)
+		c.Build(strings.NewReader(text))
+		c.SetRand(rand.New(rand.NewSource(seed)))
+		var out []string
+		for i := 0; i < 10; i++ {
+			out = append(out, c.Generate("zebras", 2, 30))
+		}
+		return out
+	}
+	if a, b := generate(42), generate(42); !reflect.DeepEqual(a, b) {
+		t.Errorf("same seed generated %q and %q", a, b)
+	}
+}
+
+func TestGenerateN(t *testing.T) {
+	c := newTestChain(1, "the cat sat. the dog ran. the cow ate. the pig slept. the hen flew.")
+	got := c.GenerateN("", 3, 1, 20)
+	if len(got) != 3 {
+		t.Fatalf("GenerateN(3) = %q, want 3 results", got)
+	}
+	seen := make(map[string]bool)
+	for _, s := range got {
+		if seen[s] {
+			t.Errorf("GenerateN(3) = %q, with duplicates", got)
+		}
+		seen[s] = true
+	}
+}
+
+func TestGenerateUnchanged(t *testing.T) {
+	// Generated with one strings.Join per prefix tail, before
+	// lookups shared a single join
+	want := []string{
+		"the cat sat on the log. the cat ate the dog's dinner! did the dog sat on the mat.",
+		"the cat ate the dog's dinner! did the dog mind? the dog mind?",
+		"the cat sat on the mat. the dog minded very much. Dog minded very much.",
+	}
+	c := newTestChain(2, generateText)
+	for _, w := range want {
+		if got := c.Generate("", 3, 100); got != w {
+			t.Errorf("Generate = %q, want %q", got, w)
+		}
+	}
+	if got, w := c.Generate("the dog", 2, 100), "the dog minded very much. Mind?"; got != w {
+		t.Errorf("Generate from \"the dog\" = %q, want %q", got, w)
+	}
+}
+
+func TestSortedSuffixesInvalidated(t *testing.T) {
+	now := time.Date(2016, time.March, 14, 15, 9, 26, 0, time.UTC)
+	c := NewChain(1)
+	c.SetNow(func() time.Time { return now })
+	c.Add(Prefix{"the"}, "mat")
+	if got := c.NextWord(Prefix{"the"}); got != "mat" {
+		t.Fatalf("got %q, want mat", got)
+	}
+
+	now = now.Add(2 * time.Hour)
+	c.Add(Prefix{"the"}, "cat")
+	if got, want := c.sortedSuffixes("the"), []string{"cat", "mat"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after adding a suffix, sorted suffixes %v, want %v", got, want)
+	}
+
+	c.DecayOlderThan(time.Hour, 0)
+	if got, want := c.sortedSuffixes("the"), []string{"cat"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after decaying a suffix, sorted suffixes %v, want %v", got, want)
+	}
+	if got := c.NextWord(Prefix{"the"}); got != "cat" {
+		t.Errorf("generated %q from a forgotten transition", got)
+	}
+
+	c.Reset()
+	if got := c.sortedSuffixes("the"); len(got) != 0 {
+		t.Errorf("after reset, sorted suffixes %v", got)
+	}
+}
+
+func TestDecayOlderThan(t *testing.T) {
+	now := time.Date(2016, time.March, 14, 15, 9, 26, 0, time.UTC)
+	c := NewChain(1)
+	c.SetNow(func() time.Time { return now })
+	c.Build(strings.NewReader("old news old news old news old news"))
+	now = now.Add(2 * time.Hour)
+	c.Build(strings.NewReader("fresh bread fresh bread"))
+
+	c.DecayOlderThan(time.Hour, 0.5)
+	if got := c.chain["old"]["news"]; got != 2 {
+		t.Errorf("old transition has frequency %d after decaying, want 2", got)
+	}
+	if got := c.chain["fresh"]["bread"]; got != 2 {
+		t.Errorf("recent transition has frequency %d after decaying, want 2", got)
+	}
+
+	c.DecayOlderThan(time.Hour, 0.1)
+	if _, ok := c.chain["old"]; ok {
+		t.Errorf("old transitions %v not removed after decaying to zero", c.chain["old"])
+	}
+	if _, ok := c.lastSeen["old"]; ok {
+		t.Error("last-seen times of removed transitions kept")
+	}
+	if got := c.chain["fresh"]["bread"]; got != 2 {
+		t.Errorf("recent transition has frequency %d after decaying, want 2", got)
+	}
+
+	// Reinforcing a transition makes it recent again
+	now = now.Add(2 * time.Hour)
+	c.Build(strings.NewReader("fresh bread"))
+	c.DecayOlderThan(time.Hour, 0)
+	if got := c.chain["fresh"]["bread"]; got != 3 {
+		t.Errorf("reinforced transition has frequency %d, want 3", got)
+	}
+}
+
+func TestLastSeenSaved(t *testing.T) {
+	now := time.Date(2016, time.March, 14, 15, 9, 26, 0, time.UTC)
+	c := NewChain(1)
+	c.SetNow(func() time.Time { return now })
+	c.Build(strings.NewReader("old news"))
+	filename := path.Join(t.TempDir(), "chain.json")
+	if err := c.Save(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewChain(1)
+	loaded.SetNow(func() time.Time { return now.Add(2 * time.Hour) })
+	if err := loaded.Load(filename); err != nil {
+		t.Fatal(err)
+	}
+	loaded.DecayOlderThan(time.Hour, 0)
+	if len(loaded.chain) != 0 {
+		t.Errorf("loaded transitions %v forgot when they were seen", loaded.chain)
+	}
+
+	// Transitions from saves without times count as seen on load
+	legacy := NewChain(1)
+	legacy.SetNow(func() time.Time { return now.Add(2 * time.Hour) })
+	if err := legacy.Load(writeJSON(t, c.chain)); err != nil {
+		t.Fatal(err)
+	}
+	legacy.DecayOlderThan(time.Hour, 0)
+	if !reflect.DeepEqual(legacy.chain, c.chain) {
+		t.Errorf("legacy transitions %v decayed, want %v", legacy.chain, c.chain)
+	}
+}
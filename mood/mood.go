@@ -9,6 +9,11 @@
 
 package mood
 
+import (
+	"fmt"
+	"strings"
+)
+
 // Mood is a type for Clyde's moods.
 type Mood int
 
@@ -25,6 +30,18 @@ const (
 	max	Mood = 7
 )
 
+// Count is the number of moods.
+const Count = int(max) + 1
+
+// All returns every mood, from worst to best.
+func All() []Mood {
+	moods := make([]Mood, Count)
+	for i := range moods {
+		moods[i] = Mood(i)
+	}
+	return moods
+}
+
 // Better returns the first mood better than the current mood.
 func (m Mood) Better() Mood {
 	if m + 1 > max {
@@ -43,6 +60,30 @@ func (m Mood) Worse() Mood {
 	}
 }
 
+// BetterBy returns the mood n steps better than the current mood, but
+// no better than the best mood. A negative n makes the mood worse.
+func (m Mood) BetterBy(n int) Mood {
+	if n < 0 {
+		return m.WorseBy(-n)
+	}
+	for i := 0; i < n; i++ {
+		m = m.Better()
+	}
+	return m
+}
+
+// WorseBy returns the mood n steps worse than the current mood, but no
+// worse than the worst mood. A negative n makes the mood better.
+func (m Mood) WorseBy(n int) Mood {
+	if n < 0 {
+		return m.BetterBy(-n)
+	}
+	for i := 0; i < n; i++ {
+		m = m.Worse()
+	}
+	return m
+}
+
 // AtLeastOk returns Ok if the current mood is less than Ok, otherwise
 // it returns the current mood.
 func (m Mood) AtLeastOk() Mood {
@@ -102,3 +143,40 @@ func (m Mood) Punc() string {
 		return "."
 	}
 }
+
+// Emoji returns an emoticon representing the current mood.
+func (m Mood) Emoji() string {
+	switch m {
+	case Yucky:
+		return "(×_×)"
+	case Angry:
+		return "(╯°□°)╯︵ ┻━┻"
+	case Unhappy:
+		return "(._.)"
+	case Lonely:
+		return ":("
+	case Turnip:
+		return "(o_o)"
+	case Ok:
+		return ":|"
+	case Good:
+		return ":)"
+	case Great:
+		return "\\o/"
+	default:
+		return ":|"
+	}
+}
+
+// FromString returns the mood described by the given string, as
+// produced by String (the leading "a" of "a turnip" is optional). It
+// returns an error if the string doesn't describe any mood.
+func FromString(s string) (Mood, error) {
+	s = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(s)), "a ")
+	for _, m := range All() {
+		if strings.TrimPrefix(m.String(), "a ") == s {
+			return m, nil
+		}
+	}
+	return Ok, fmt.Errorf("unknown mood %q", s)
+}
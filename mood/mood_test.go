@@ -0,0 +1,97 @@
+// Copyright 2016 Sam Dukhovni <dukhovni@mit.edu>
+//
+// Licensed under the MIT License
+// (https://opensource.org/licenses/MIT)
+
+package mood
+
+import (
+	"testing"
+)
+
+func TestFromString(t *testing.T) {
+	tests := []struct {
+		s string
+		want Mood
+	}{
+		{"lonely", Lonely},
+		{"  Great ", Great},
+		{"a turnip", Turnip},
+		{"turnip", Turnip},
+	}
+	for _, test := range tests {
+		if got, err := FromString(test.s); err != nil || got != test.want {
+			t.Errorf("FromString(%q) = %v, %v, want %v", test.s, got, err, test.want)
+		}
+	}
+	if _, err := FromString("sleepy"); err == nil {
+		t.Error("FromString accepted an unknown mood")
+	}
+}
+
+func TestEmoji(t *testing.T) {
+	tests := []struct {
+		m Mood
+		want string
+	}{
+		{Yucky, "(×_×)"},
+		{Angry, "(╯°□°)╯︵ ┻━┻"},
+		{Unhappy, "(._.)"},
+		{Lonely, ":("},
+		{Turnip, "(o_o)"},
+		{Ok, ":|"},
+		{Good, ":)"},
+		{Great, "\\o/"},
+	}
+	for _, test := range tests {
+		if got := test.m.Emoji(); got != test.want {
+			t.Errorf("%v.Emoji() = %q, want %q", test.m, got, test.want)
+		}
+	}
+	if Mood(42).Emoji() == "" {
+		t.Error("unknown mood has no emoji")
+	}
+}
+
+func TestBetterBy(t *testing.T) {
+	tests := []struct {
+		m Mood
+		n int
+		better, worse Mood
+	}{
+		{Ok, 0, Ok, Ok},
+		{Ok, 1, Good, Turnip},
+		{Ok, 2, Great, Lonely},
+		{Ok, 10, Great, Yucky},
+		{Great, 1, Great, Good},
+		{Yucky, 1, Angry, Yucky},
+		{Ok, -2, Lonely, Great},
+		{Angry, -3, Yucky, Turnip},
+	}
+	for _, test := range tests {
+		if got := test.m.BetterBy(test.n); got != test.better {
+			t.Errorf("%v.BetterBy(%d) = %v, want %v", test.m, test.n, got, test.better)
+		}
+		if got := test.m.WorseBy(test.n); got != test.worse {
+			t.Errorf("%v.WorseBy(%d) = %v, want %v", test.m, test.n, got, test.worse)
+		}
+	}
+}
+
+func TestAll(t *testing.T) {
+	all := All()
+	if len(all) != Count {
+		t.Fatalf("All() has %d moods, want %d", len(all), Count)
+	}
+	if all[0] != Yucky || all[Count-1] != Great {
+		t.Errorf("All() = %v, want Yucky through Great", all)
+	}
+	for i, m := range all {
+		if i > 0 && m != all[i-1].Better() {
+			t.Errorf("All() out of order at %d: %v", i, all)
+		}
+		if m.String() == "" {
+			t.Errorf("mood %d has no name", int(m))
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2016 Sam Dukhovni <dukhovni@mit.edu>
+//
+// Licensed under the MIT License
+// (https://opensource.org/licenses/MIT)
+//
+//
+// stats.go defines counters and snapshots for keeping track of what
+// Clyde has been up to.
+
+package clyde
+
+import (
+	"sync"
+	"github.com/sdukhovni/clyde-go/cat"
+	"github.com/sdukhovni/clyde-go/mood"
+)
+
+// Stats is a snapshot of Clyde's message-processing counters.
+type Stats struct {
+	// MessagesSeen is the number of zephyrs Clyde has processed.
+	MessagesSeen int
+	// BehaviorCounts maps each behavior's index in the behavior
+	// list to the number of times it has triggered.
+	BehaviorCounts map[int]int
+	// SendErrors is the number of zephyrs Clyde failed to send.
+	SendErrors int
+}
+
+// counters holds Clyde's running statistics; it is safe for
+// concurrent use.
+type counters struct {
+	sync.Mutex
+	stats Stats
+}
+
+func (s *counters) messageSeen() {
+	s.Lock()
+	defer s.Unlock()
+	s.stats.MessagesSeen++
+}
+
+func (s *counters) behaviorTriggered(i int) {
+	s.Lock()
+	defer s.Unlock()
+	if s.stats.BehaviorCounts == nil {
+		s.stats.BehaviorCounts = make(map[int]int)
+	}
+	s.stats.BehaviorCounts[i]++
+}
+
+func (s *counters) sendFailed() {
+	s.Lock()
+	defer s.Unlock()
+	s.stats.SendErrors++
+}
+
+// Stats returns a snapshot of Clyde's message-processing counters.
+func (c *Clyde) Stats() Stats {
+	c.counters.Lock()
+	defer c.counters.Unlock()
+
+	snapshot := c.counters.stats
+	snapshot.BehaviorCounts = make(map[int]int)
+	for i, count := range c.counters.stats.BehaviorCounts {
+		snapshot.BehaviorCounts[i] = count
+	}
+	return snapshot
+}
+
+// published holds copies of Clyde's mood and cat state for other
+// goroutines to read. Only the event loop touches c.mood and c.cat,
+// so it can use them without locking; it copies them here after
+// handling each event.
+type published struct {
+	sync.RWMutex
+	mood mood.Mood
+	cat cat.Cat
+}
+
+// publishState makes Clyde's current mood and cat state visible to
+// Mood and Cat.
+func (c *Clyde) publishState() {
+	c.published.Lock()
+	defer c.published.Unlock()
+
+	c.published.mood = c.mood
+	c.published.cat = c.cat
+	c.published.cat.PlaySuccesses = make(map[string]int)
+	for cmd, count := range c.cat.PlaySuccesses {
+		c.published.cat.PlaySuccesses[cmd] = count
+	}
+}
+
+// Mood returns Clyde's mood as of the last event he handled. It is
+// safe to call while Clyde is running.
+func (c *Clyde) Mood() mood.Mood {
+	c.published.RLock()
+	defer c.published.RUnlock()
+	return c.published.mood
+}
+
+// Cat returns what Clyde knew about the cat as of the last event he
+// handled. It is safe to call while Clyde is running, but the returned
+// Cat must not be modified.
+func (c *Clyde) Cat() cat.Cat {
+	c.published.RLock()
+	defer c.published.RUnlock()
+	return c.published.cat
+}
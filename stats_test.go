@@ -0,0 +1,122 @@
+// Copyright 2016 Sam Dukhovni <dukhovni@mit.edu>
+//
+// Licensed under the MIT License
+// (https://opensource.org/licenses/MIT)
+
+package clyde
+
+import (
+	"sync"
+	"testing"
+	"github.com/sdukhovni/clyde-go/cat"
+	"github.com/sdukhovni/clyde-go/mood"
+)
+
+func TestCounters(t *testing.T) {
+	c := &Clyde{}
+	c.counters.messageSeen()
+	c.counters.messageSeen()
+	c.counters.behaviorTriggered(3)
+	c.counters.behaviorTriggered(3)
+	c.counters.behaviorTriggered(5)
+	c.counters.sendFailed()
+
+	stats := c.Stats()
+	if stats.MessagesSeen != 2 || stats.SendErrors != 1 {
+		t.Errorf("got stats %+v", stats)
+	}
+	if len(stats.BehaviorCounts) != 2 || stats.BehaviorCounts[3] != 2 || stats.BehaviorCounts[5] != 1 {
+		t.Errorf("behavior counts %v", stats.BehaviorCounts)
+	}
+
+	// The snapshot is a copy
+	stats.BehaviorCounts[3] = 100
+	if c.Stats().BehaviorCounts[3] == 100 {
+		t.Error("modifying a snapshot changed Clyde's counters")
+	}
+}
+
+// TestConcurrentState is most useful with -race.
+func TestConcurrentState(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	c.Run()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if m := c.Mood(); m < mood.Yucky || m > mood.Great {
+					t.Errorf("read mood %d", int(m))
+				}
+				kitty := c.Cat()
+				for range kitty.PlaySuccesses {
+				}
+				c.Stats()
+			}
+		}()
+	}
+
+	for i := 0; i < 20; i++ {
+		ft.deliver(homeMessage("clyde, *hug*"))
+		ft.deliver(catMessage(cat.CatName, "zeroday purrs"))
+		ft.deliver(homeMessage("CLYDE, WHY WON'T YOU LISTEN"))
+	}
+	close(stop)
+	wg.Wait()
+	c.Shutdown()
+
+	if c.Mood() != c.mood || c.Cat().State != c.cat.State {
+		t.Errorf("published mood %v and cat %v, want %v and %v", c.Mood(), c.Cat().State, c.mood, c.cat.State)
+	}
+}
+
+// behaviorIndex returns the index of the named behavior in the
+// behavior list.
+func behaviorIndex(t *testing.T, name string) int {
+	t.Helper()
+	for i, b := range behaviors {
+		if b.name == name {
+			return i
+		}
+	}
+	t.Fatalf("no behavior named %q", name)
+	return -1
+}
+
+func TestStats(t *testing.T) {
+	c, ft, _ := newTestClyde(t, "")
+	reply(t, c, ft, homeMessage("clyde, roll 2d6"))
+	reply(t, c, ft, homeMessage("clyde, flip a coin"))
+	reply(t, c, ft, homeMessage("3d6"))
+
+	stats := c.Stats()
+	if stats.MessagesSeen != 3 {
+		t.Errorf("saw %d messages, want 3", stats.MessagesSeen)
+	}
+	want := map[int]int{
+		behaviorIndex(t, "dice"): 2,
+		behaviorIndex(t, "coin"): 1,
+	}
+	if len(stats.BehaviorCounts) != len(want) {
+		t.Errorf("behavior counts %v, want %v", stats.BehaviorCounts, want)
+	}
+	for i, count := range want {
+		if stats.BehaviorCounts[i] != count {
+			t.Errorf("%s triggered %d times, want %d", behaviors[i].name, stats.BehaviorCounts[i], count)
+		}
+	}
+
+	// The snapshot is a copy
+	stats.BehaviorCounts[0] = 100
+	if c.Stats().BehaviorCounts[0] == 100 {
+		t.Error("modifying a snapshot changed Clyde's counters")
+	}
+}
@@ -13,18 +13,58 @@ import (
 	"strings"
 	"unicode/utf8"
 	"regexp"
+	"strconv"
+	"unicode"
 )
 
 const MaxLine = 70
 
 func BreakLines(s string, maxLine int) string {
+	return breakLines(s, maxLine, utf8.RuneCountInString)
+}
+
+// BreakLinesWidth is like BreakLines, but measures lines by their
+// display width, so that East Asian wide and fullwidth characters
+// count as two columns.
+func BreakLinesWidth(s string, maxWidth int) string {
+	return breakLines(s, maxWidth, DisplayWidth)
+}
+
+// isWide reports whether r is an East Asian wide or fullwidth
+// character, which takes up two columns in a terminal.
+func isWide(r rune) bool {
+	switch {
+	case r >= 0x1100 && r <= 0x115f, // Hangul Jamo initials
+		r >= 0x2e80 && r <= 0x303e, // CJK radicals and punctuation
+		r >= 0xff00 && r <= 0xff60, // Fullwidth forms
+		r >= 0xffe0 && r <= 0xffe6:
+		return true
+	}
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// DisplayWidth returns the number of columns s takes up when
+// displayed, counting wide characters as two columns.
+func DisplayWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		if isWide(r) {
+			width += 2
+		} else {
+			width++
+		}
+	}
+	return width
+}
+
+func breakLines(s string, maxLine int, width func(string) int) string {
 	words := strings.Fields(s)
 	var lines []string
 	var line []string
 	length := -1
 
 	for _,w := range words {
-		wordLength := utf8.RuneCountInString(w) + 1
+		wordLength := width(w) + 1
 		if length + wordLength > maxLine && length != 0 {
 			lines = append(lines, strings.Join(line, " "))
 			line = line[:0]
@@ -65,6 +105,24 @@ func Capitalize(w string) string {
 	}
 }
 
+var wordPattern = regexp.MustCompile("\\S+")
+var standaloneI = regexp.MustCompile("^i\\b")
+
+// RecapitalizeSentences returns s with the first word of each
+// sentence capitalized, as well as the word "i".
+func RecapitalizeSentences(s string) string {
+	sentenceStart := true
+	return wordPattern.ReplaceAllStringFunc(s, func(w string) string {
+		if sentenceStart {
+			w = Capitalize(w)
+		} else {
+			w = standaloneI.ReplaceAllString(w, "I")
+		}
+		sentenceStart = IsEndOfSentence(w)
+		return w
+	})
+}
+
 // Escape escapes a string to make it suitable for use in a
 // filename. Specifically, all non-printable byte sequences (as judged
 // by fmt's %q verb) and all '/' characters will be replaced with
@@ -86,6 +144,209 @@ func Escape(s string) string {
 	return strings.Join(chars, "")
 }
 
+// Unescape reverses Escape, returning an error if s contains an
+// invalid escape sequence.
+func Unescape(s string) (string, error) {
+	var b strings.Builder
+
+	for len(s) > 0 {
+		value, multibyte, tail, err := strconv.UnquoteChar(s, '\'')
+		if err != nil {
+			return "", err
+		}
+		if value < utf8.RuneSelf || multibyte {
+			b.WriteRune(value)
+		} else {
+			b.WriteByte(byte(value))
+		}
+		s = tail
+	}
+
+	return b.String(), nil
+}
+
+var nonSpace = regexp.MustCompile("\\S+")
+var pigLatinWord = regexp.MustCompile("^([^A-Za-z]*)([A-Za-z][A-Za-z']*[A-Za-z]|[A-Za-z])([^A-Za-z]*)$")
+
+// PigLatin translates each word in s to pig latin, preserving
+// spacing, punctuation around words, and capitalization. Tokens that
+// aren't words are left unchanged.
+func PigLatin(s string) string {
+	return nonSpace.ReplaceAllStringFunc(s, func(token string) string {
+		parts := pigLatinWord.FindStringSubmatch(token)
+		if parts == nil {
+			return token
+		}
+		return parts[1] + pigLatinize(parts[2]) + parts[3]
+	})
+}
+
+// pigLatinize translates a single word to pig latin.
+func pigLatinize(w string) string {
+	lower := strings.ToLower(w)
+
+	// Find the initial consonant cluster, treating "qu" as a
+	// consonant and "y" as a vowel unless it starts the word
+	split := 0
+	for split < len(lower) {
+		ch := lower[split]
+		if strings.IndexByte("aeiou", ch) >= 0 || (ch == 'y' && split > 0) {
+			break
+		}
+		if ch == 'q' && split+1 < len(lower) && lower[split+1] == 'u' {
+			split++
+		}
+		split++
+	}
+
+	var result string
+	if split == 0 {
+		result = lower + "way"
+	} else {
+		result = lower[split:] + lower[:split] + "ay"
+	}
+
+	if w == strings.ToUpper(w) && len(w) > 1 {
+		return strings.ToUpper(result)
+	}
+	if w[0] != lower[0] {
+		return Capitalize(result)
+	}
+	return result
+}
+
+// Reverse returns s with its runes in reverse order.
+func Reverse(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+// zephyrDelimiters maps the opening delimiters of zephyr @-markup to
+// their closing delimiters.
+var zephyrDelimiters = map[rune]rune{'[': ']', '{': '}', '<': '>', '(': ')'}
+
+// zephyrDirectives are zephyr @-markup commands whose argument isn't
+// text, e.g. @color(red).
+var zephyrDirectives = map[string]bool{"color": true, "font": true}
+
+// StripZephyrFormat removes zephyr @-markup such as @b[...] or
+// @i{...} from s, keeping the enclosed text. "@@" becomes a literal
+// "@". Markup that is never closed is left alone, as are stray
+// closing delimiters.
+func StripZephyrFormat(s string) string {
+	runes := []rune(s)
+
+	// First match each opening "@word[" with its closing delimiter,
+	// so that unclosed markup can be left alone
+	type opening struct {
+		at int
+		closer rune
+	}
+	closes := make(map[int]int) // index of '@' -> index of its closer
+	var open []opening
+	for i := 0; i < len(runes); i++ {
+		// A closer ends the innermost markup it can close; any
+		// markup opened inside that is left unclosed
+		k := len(open)-1
+		for k >= 0 && runes[i] != open[k].closer {
+			k--
+		}
+		if k >= 0 {
+			closes[open[k].at] = i
+			open = open[:k]
+			continue
+		}
+		if j, closer, ok := zephyrMarkupAt(runes, i); ok {
+			open = append(open, opening{i, closer})
+			i = j
+		} else if runes[i] == '@' && i+1 < len(runes) && runes[i+1] == '@' {
+			i++
+		}
+	}
+
+	var out []rune
+	closers := make(map[int]bool)
+	for i := 0; i < len(runes); i++ {
+		if closers[i] {
+			continue
+		}
+		if end, ok := closes[i]; ok {
+			j, _, _ := zephyrMarkupAt(runes, i)
+			if zephyrDirectives[strings.ToLower(string(runes[i+1:j]))] {
+				i = end
+			} else {
+				closers[end] = true
+				i = j
+			}
+			continue
+		}
+		if runes[i] == '@' && i+1 < len(runes) && runes[i+1] == '@' {
+			i++
+		}
+		out = append(out, runes[i])
+	}
+	return string(out)
+}
+
+// zephyrMarkupAt reports whether runes[i:] starts with the opening of
+// zephyr @-markup, "@word" followed by an opening delimiter. If so, it
+// returns the index of the delimiter and the matching closer.
+func zephyrMarkupAt(runes []rune, i int) (int, rune, bool) {
+	if runes[i] != '@' {
+		return 0, 0, false
+	}
+	j := i+1
+	for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j])) {
+		j++
+	}
+	if j == len(runes) {
+		return 0, 0, false
+	}
+	closer, ok := zephyrDelimiters[runes[j]]
+	return j, closer, ok
+}
+
+// Ellipsis is appended to strings shortened by Truncate.
+const Ellipsis = "…"
+
+// Truncate shortens s to at most max runes, replacing the end with an
+// ellipsis if anything was cut off. It never splits a rune.
+func Truncate(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	if max < 1 {
+		return ""
+	}
+	runes := []rune(s)
+	return strings.TrimRightFunc(string(runes[:max-1]), unicode.IsSpace) + Ellipsis
+}
+
+// ShoutingRatio is the fraction of letters in a string that must be
+// uppercase for IsShouting to consider it shouting.
+var ShoutingRatio = 0.8
+
+// ShoutingMinLetters is the fewest letters a string can have and be
+// considered shouting, so that short acronyms don't count.
+var ShoutingMinLetters = 8
+
+// IsShouting reports whether s is mostly uppercase letters.
+func IsShouting(s string) bool {
+	letters, upper := 0, 0
+	for _, r := range s {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+	}
+	return letters >= ShoutingMinLetters && float64(upper) >= ShoutingRatio*float64(letters)
+}
+
 // Syllable-counting regexp courtesy of StackOverflow user Sp3000
 //var syl = regexp.MustCompile("/[aiouy]+e*|e(?!d$|ly$).|[td]ed|le$/")
 var syl = regexp.MustCompile("/[aeiouy]+/")
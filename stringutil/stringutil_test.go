@@ -0,0 +1,197 @@
+// Copyright 2016 Sam Dukhovni <dukhovni@mit.edu>
+//
+// Licensed under the MIT License
+// (https://opensource.org/licenses/MIT)
+
+package stringutil
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestUnescape(t *testing.T) {
+	for _, s := range []string{"ben bitdiddle", "a/b", "it's \"quoted\"", "tab\there", "naïve ☃", ""} {
+		got, err := Unescape(Escape(s))
+		if err != nil {
+			t.Errorf("Unescape(Escape(%q)) returned %v", s, err)
+		} else if got != s {
+			t.Errorf("Unescape(Escape(%q)) = %q", s, got)
+		}
+	}
+
+	if _, err := Unescape("bad\\q"); err == nil {
+		t.Error("Unescape accepted an invalid escape sequence")
+	}
+}
+
+func TestPigLatin(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"apple", "appleway"},
+		{"egg omelette", "eggway omeletteway"},
+		{"string", "ingstray"},
+		{"cheese", "eesechay"},
+		{"quiet", "ietquay"},
+		{"yellow rhythm", "ellowyay ythmrhay"},
+		{"Hello, world!", "Ellohay, orldway!"},
+		{"\"Pig\" latin?", "\"Igpay\" atinlay?"},
+		{"don't", "on'tday"},
+		{"NASA", "ASANAY"},
+		{"call  me at 555-1234 :)", "allcay  emay atway 555-1234 :)"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := PigLatin(test.in); got != test.want {
+			t.Errorf("PigLatin(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestReverse(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"hello", "olleh"},
+		{"héllo 🐱!", "!🐱 olléh"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := Reverse(test.in); got != test.want {
+			t.Errorf("Reverse(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestIsShouting(t *testing.T) {
+	tests := []struct {
+		s string
+		want bool
+	}{
+		{"WHY WON'T YOU LISTEN", true},
+		{"WHY WON'T YOU LISTEN to me", true},
+		{"NASA", false},
+		{"I LOVE NASA and the ISS", false},
+		{"Why Won't You Listen", false},
+		{"1234567890!!!", false},
+	}
+	for _, test := range tests {
+		if got := IsShouting(test.s); got != test.want {
+			t.Errorf("IsShouting(%q) = %v, want %v", test.s, got, test.want)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s string
+		max int
+		want string
+	}{
+		{"hello", 10, "hello"},
+		{"hello", 5, "hello"},
+		{"hello world", 5, "hell…"},
+		{"hello world", 7, "hello…"},
+		{"日本語のテキスト", 8, "日本語のテキスト"},
+		{"日本語のテキスト", 4, "日本語…"},
+		{"🐱🐱🐱", 2, "🐱…"},
+		{"hello", 1, "…"},
+		{"hello", 0, ""},
+		{"", 0, ""},
+	}
+	for _, test := range tests {
+		got := Truncate(test.s, test.max)
+		if got != test.want {
+			t.Errorf("Truncate(%q, %d) = %q, want %q", test.s, test.max, got, test.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("Truncate(%q, %d) = %q, which isn't valid UTF-8", test.s, test.max, got)
+		}
+	}
+}
+
+func TestRecapitalizeSentences(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"the cat sat. the dog ran! did it? yes", "The cat sat. The dog ran! Did it? Yes"},
+		{"then i said i'm it", "Then I said I'm it"},
+		{"i think so", "I think so"},
+		{"no punctuation here", "No punctuation here"},
+		{"keeps  its\nspacing. ok", "Keeps  its\nspacing. Ok"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := RecapitalizeSentences(test.in); got != test.want {
+			t.Errorf("RecapitalizeSentences(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestDisplayWidth(t *testing.T) {
+	tests := []struct {
+		s string
+		want int
+	}{
+		{"", 0},
+		{"hello", 5},
+		{"你好", 4},
+		{"こんにちは world", 16},
+		{"ＡＢＣ", 6},
+		{"한국어", 6},
+		{"café", 4},
+	}
+	for _, test := range tests {
+		if got := DisplayWidth(test.s); got != test.want {
+			t.Errorf("DisplayWidth(%q) = %d, want %d", test.s, got, test.want)
+		}
+	}
+}
+
+func TestBreakLinesWidth(t *testing.T) {
+	s := "hello 你好世界 world こんにちは 世界 ok 漢字漢字漢字"
+	const maxWidth = 12
+	if BreakLines(s, maxWidth) == BreakLinesWidth(s, maxWidth) {
+		t.Error("BreakLinesWidth wrapped like BreakLines")
+	}
+	got := BreakLinesWidth(s, maxWidth)
+	for _, line := range strings.Split(got, "\n") {
+		if DisplayWidth(line) > maxWidth {
+			t.Errorf("line %q is %d columns wide", line, DisplayWidth(line))
+		}
+	}
+	if strings.Join(strings.Fields(got), " ") != s {
+		t.Errorf("BreakLinesWidth lost words: %q", got)
+	}
+
+	ascii := "the quick brown fox jumps over the lazy dog"
+	if got, want := BreakLinesWidth(ascii, 10), BreakLines(ascii, 10); got != want {
+		t.Errorf("BreakLinesWidth(%q) = %q, want %q", ascii, got, want)
+	}
+}
+
+func TestStripZephyrFormat(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"@b[bold] text", "bold text"},
+		{"@i{italic} and @B<loud>", "italic and loud"},
+		{"@b[@i(nested)] end", "nested end"},
+		{"@color(red)hello @font(fixed)world", "hello world"},
+		{"a@@b", "a@b"},
+		{"dukhovni@mit.edu", "dukhovni@mit.edu"},
+		{"@b[unclosed", "@b[unclosed"},
+		{"stray ] here", "stray ] here"},
+		{"@b[one @i{two] three}", "one @i{two three}"},
+		{"@", "@"},
+		{"@b", "@b"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := StripZephyrFormat(test.in); got != test.want {
+			t.Errorf("StripZephyrFormat(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright 2016 Sam Dukhovni <dukhovni@mit.edu>
+//
+// Adapted from clyde.pl by cat@mit.edu
+//
+// Licensed under the MIT License
+// (https://opensource.org/licenses/MIT)
+//
+// Some code snippets copied from the zephyr-go library
+// (https://github.com/zephyr-im/zephyr-go), (c) 2014 The zephyr-go
+// authors, licensed under the Apache License, Version 2.0
+// (http://www.apache.org/licenses/LICENSE-2.0)
+
+package clyde
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+	"github.com/zephyr-im/krb5-go"
+	"github.com/zephyr-im/zephyr-go"
+)
+
+// A Transport is how Clyde sends and receives zephyrs. LoadClyde uses
+// a real zephyr session; NewClydeWithTransport accepts any Transport,
+// e.g. one that doesn't talk to zephyr at all.
+type Transport interface {
+	// Send sends a zephyr with the given zsig and body; the
+	// recipient is empty except for personals.
+	Send(class, instance, recipient, zsig, body string) error
+	// Subscribe subscribes to the given triples.
+	Subscribe(subs []zephyr.Subscription) error
+	// CancelSubscriptions cancels all subscriptions.
+	CancelSubscriptions() error
+	// Messages returns the channel of incoming zephyrs, which is
+	// closed if the transport loses its connection.
+	Messages() <-chan zephyr.MessageReaderResult
+	// Reconnect tries to replace a lost connection, returning
+	// ErrNoReconnect if it never can.
+	Reconnect() error
+	// Close releases any resources held by the transport.
+	Close() error
+}
+
+// ErrNoReconnect is returned by a Transport's Reconnect method when
+// its connection is gone for good, so Clyde shouldn't keep trying.
+var ErrNoReconnect = errors.New("transport can't reconnect")
+
+// zephyrTransport is a Transport backed by a real zephyr session.
+type zephyrTransport struct {
+	session *zephyr.Session
+	ctx *krb5.Context
+}
+
+// dialZephyr connects to zephyr using the system default
+// configuration.
+func dialZephyr() (*zephyrTransport, error) {
+	session, err := zephyr.DialSystemDefault()
+	if err != nil {
+		return nil, err
+	}
+
+	// Create krb5 context for subscriptions
+	ctx, err := krb5.NewContext()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	return &zephyrTransport{session, ctx}, nil
+}
+
+func (t *zephyrTransport) Send(class, instance, recipient, zsig, body string) error {
+	msg := &zephyr.Message{
+		Header: zephyr.Header{
+			Kind:	zephyr.ACKED,
+			UID:	t.session.MakeUID(time.Now()),
+			Port:	t.session.Port(),
+			Class:	class, Instance: instance,
+			OpCode: "AUTO",
+			Sender:		sender,
+			Recipient:	recipient,
+			DefaultFormat:	"http://mit.edu/df/",
+			SenderAddress:	t.session.LocalAddr().IP,
+			Charset:	zephyr.CharsetUTF8,
+			OtherFields:	nil,
+		},
+		Body: []string{zsig, body},
+	}
+	_, err := t.session.SendMessageUnauth(msg)
+	return err
+}
+
+func (t *zephyrTransport) Subscribe(subs []zephyr.Subscription) error {
+	_, err := t.session.SendSubscribeNoDefaults(t.ctx, subs)
+	return err
+}
+
+func (t *zephyrTransport) CancelSubscriptions() error {
+	_, err := t.session.SendCancelSubscriptions(t.ctx)
+	return err
+}
+
+func (t *zephyrTransport) Messages() <-chan zephyr.MessageReaderResult {
+	return t.session.Messages()
+}
+
+func (t *zephyrTransport) Reconnect() error {
+	session, err := zephyr.DialSystemDefault()
+	if err != nil {
+		return err
+	}
+	t.session.Close()
+	t.session = session
+	return nil
+}
+
+func (t *zephyrTransport) Close() error {
+	err := t.session.Close()
+	t.ctx.Free()
+	return err
+}
+
+// dryRunTransport is a Transport that writes outgoing zephyrs to a
+// writer instead of sending them, and reads incoming zephyrs one per
+// line from a reader.
+type dryRunTransport struct {
+	out io.Writer
+	user string
+	mu sync.Mutex
+	home *zephyr.Subscription
+	subscribed chan struct{} // closed once home is set
+	messages chan zephyr.MessageReaderResult
+}
+
+// NewDryRunTransport returns a Transport that doesn't use zephyr at
+// all: each line read from in is delivered as a zephyr from user on
+// the first class and instance Clyde subscribes to (his primary
+// home), and everything Clyde sends or subscribes to is written to
+// out. Nothing is read from in until Clyde subscribes to something.
+// The message channel closes when in reaches EOF.
+func NewDryRunTransport(in io.Reader, out io.Writer, user string) Transport {
+	t := &dryRunTransport{
+		out: out,
+		user: user,
+		subscribed: make(chan struct{}),
+		messages: make(chan zephyr.MessageReaderResult),
+	}
+	go t.read(in)
+	return t
+}
+
+func (t *dryRunTransport) read(in io.Reader) {
+	defer close(t.messages)
+	<-t.subscribed
+	t.mu.Lock()
+	home := t.home
+	t.mu.Unlock()
+
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		t.messages <- zephyr.MessageReaderResult{
+			Message: &zephyr.Message{
+				Header: zephyr.Header{
+					Class: home.Class,
+					Instance: home.Instance,
+					Sender: t.user,
+				},
+				Body: []string{"", scanner.Text()},
+			},
+			AuthStatus: zephyr.AuthNo,
+		}
+	}
+}
+
+func (t *dryRunTransport) Send(class, instance, recipient, zsig, body string) error {
+	to := fmt.Sprintf("-c %s -i %s", class, instance)
+	if recipient != "" {
+		to += " " + recipient
+	}
+	_, err := fmt.Fprintf(t.out, "[dry run] %s (%s)\n%s\n", to, zsig, body)
+	return err
+}
+
+func (t *dryRunTransport) Subscribe(subs []zephyr.Subscription) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, s := range subs {
+		if t.home == nil {
+			home := s
+			t.home = &home
+			close(t.subscribed)
+		}
+		fmt.Fprintf(t.out, "[dry run] subscribed to -c %s -i %s\n", s.Class, s.Instance)
+	}
+	return nil
+}
+
+func (t *dryRunTransport) CancelSubscriptions() error {
+	fmt.Fprintln(t.out, "[dry run] cancelled subscriptions")
+	return nil
+}
+
+func (t *dryRunTransport) Messages() <-chan zephyr.MessageReaderResult {
+	return t.messages
+}
+
+func (t *dryRunTransport) Reconnect() error {
+	return ErrNoReconnect
+}
+
+func (t *dryRunTransport) Close() error {
+	return nil
+}
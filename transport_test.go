@@ -0,0 +1,45 @@
+// Copyright 2016 Sam Dukhovni <dukhovni@mit.edu>
+//
+// Licensed under the MIT License
+// (https://opensource.org/licenses/MIT)
+
+package clyde
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+	"github.com/sdukhovni/clyde-go/logger"
+)
+
+func TestDryRun(t *testing.T) {
+	var out bytes.Buffer
+	tr := NewDryRunTransport(strings.NewReader("clyde, roll 1d1\nclyde, roll 1d1\n"), &out, "alice@ATHENA.MIT.EDU")
+	c, err := NewClydeWithTransport(t.TempDir(), tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.log = logger.New(io.Discard, logger.Debug)
+	c.SetClock(newFakeClock())
+	c.SetRand(rand.New(rand.NewSource(1)))
+	c.Run()
+
+	// Clyde stops once he's handled all of the input
+	select {
+	case <-c.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Clyde didn't stop at the end of the input")
+	}
+	c.Shutdown()
+
+	want := "[dry run] subscribed to -c ztoys -i clyde\n" +
+		"[dry run] -c ztoys -i clyde (Clyde)\n1\n" +
+		"[dry run] -c ztoys -i clyde (Clyde)\n1\n" +
+		"[dry run] cancelled subscriptions\n"
+	if got := out.String(); got != want {
+		t.Errorf("dry run wrote %q, want %q", got, want)
+	}
+}
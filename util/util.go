@@ -8,11 +8,19 @@
 package util
 
 import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
 	"github.com/zephyr-im/zephyr-go"
 )
 
+// MessageZSig returns the zsig of a zephyr (the second-to-last body
+// field), or an empty string if the zephyr has no zsig.
 func MessageZSig(r zephyr.MessageReaderResult) string {
 	zsig := ""
+	if r.Message == nil {
+		return zsig
+	}
 	fields := len(r.Message.Body)
 	if fields > 1 {
 		zsig = r.Message.Body[fields-2]
@@ -20,11 +28,39 @@ func MessageZSig(r zephyr.MessageReaderResult) string {
 	return zsig
 }
 
+// MessageBody returns the body of a zephyr (the last body field), or
+// an empty string if the zephyr has no body fields.
 func MessageBody(r zephyr.MessageReaderResult) string {
 	body := ""
+	if r.Message == nil {
+		return body
+	}
 	fields := len(r.Message.Body)
 	if fields > 0 {
 		body = r.Message.Body[fields-1]
 	}
 	return body
 }
+
+// IsPersonal reports whether a zephyr was sent to a particular
+// recipient, rather than to everyone on its class.
+func IsPersonal(r zephyr.MessageReaderResult) bool {
+	return r.Message != nil && r.Message.Header.Recipient != ""
+}
+
+// AddressedToClyde reports whether a zephyr's body is addressed to the
+// bot with the given name, i.e. starts (ignoring case and leading
+// whitespace) with the name, optionally followed by punctuation, and
+// then whitespace or the end of the message.
+func AddressedToClyde(r zephyr.MessageReaderResult, name string) bool {
+	body := strings.TrimSpace(MessageBody(r))
+	if len(body) < len(name) || !strings.EqualFold(body[:len(name)], name) {
+		return false
+	}
+	rest := strings.TrimLeft(body[len(name):], ",:;.!?")
+	if rest == "" {
+		return true
+	}
+	next, _ := utf8.DecodeRuneInString(rest)
+	return unicode.IsSpace(next)
+}
@@ -0,0 +1,61 @@
+// Copyright 2016 Sam Dukhovni <dukhovni@mit.edu>
+//
+// Licensed under the MIT License
+// (https://opensource.org/licenses/MIT)
+
+package util
+
+import (
+	"testing"
+	"github.com/zephyr-im/zephyr-go"
+)
+
+func body(b string) zephyr.MessageReaderResult {
+	return zephyr.MessageReaderResult{
+		Message: &zephyr.Message{Body: []string{"", b}},
+	}
+}
+
+func TestAddressedToClyde(t *testing.T) {
+	tests := []struct {
+		body string
+		want bool
+	}{
+		{"clyde", true},
+		{" Clyde: hi", true},
+		{"clyde, what time is it?", true},
+		{"CLYDE!!", true},
+		{"clydesdale", false},
+		{"hi clyde", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if got := AddressedToClyde(body(test.body), "clyde"); got != test.want {
+			t.Errorf("AddressedToClyde(%q) = %v, want %v", test.body, got, test.want)
+		}
+	}
+	if !AddressedToClyde(body("bonnie, hi"), "bonnie") {
+		t.Error("not addressed to a bot with another name")
+	}
+}
+
+func TestMessageFields(t *testing.T) {
+	tests := []struct {
+		r zephyr.MessageReaderResult
+		zsig, body string
+	}{
+		{body("hi"), "", "hi"},
+		{zephyr.MessageReaderResult{Message: &zephyr.Message{Body: []string{"sig", "hi"}}}, "sig", "hi"},
+		{zephyr.MessageReaderResult{Message: &zephyr.Message{Body: []string{"hi"}}}, "", "hi"},
+		{zephyr.MessageReaderResult{Message: &zephyr.Message{}}, "", ""},
+		{zephyr.MessageReaderResult{}, "", ""},
+	}
+	for _, test := range tests {
+		if got := MessageZSig(test.r); got != test.zsig {
+			t.Errorf("MessageZSig(%v) = %q, want %q", test.r.Message, got, test.zsig)
+		}
+		if got := MessageBody(test.r); got != test.body {
+			t.Errorf("MessageBody(%v) = %q, want %q", test.r.Message, got, test.body)
+		}
+	}
+}